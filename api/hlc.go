@@ -0,0 +1,24 @@
+package api
+
+// CompareHLC returns -1, 0 or 1 as a is before, equal to or after b. A nil
+// timestamp is before every other, the zero one included.
+func CompareHLC(a, b *HybridTimestamp) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	case a.WallNanos < b.WallNanos:
+		return -1
+	case a.WallNanos > b.WallNanos:
+		return 1
+	case a.Logical < b.Logical:
+		return -1
+	case a.Logical > b.Logical:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,26 @@
+package api
+
+import "testing"
+
+func TestCompareHLC(t *testing.T) {
+	for _, tc := range []struct {
+		a, b *HybridTimestamp
+
+		want int
+	}{
+		{nil, nil, 0},
+		{nil, &HybridTimestamp{}, -1},
+		{&HybridTimestamp{}, nil, 1},
+		{nil, &HybridTimestamp{WallNanos: 1}, -1},
+		{&HybridTimestamp{}, &HybridTimestamp{}, 0},
+		{&HybridTimestamp{WallNanos: 1, Logical: 9}, &HybridTimestamp{WallNanos: 2}, -1},
+		{&HybridTimestamp{WallNanos: 2}, &HybridTimestamp{WallNanos: 1, Logical: 9}, 1},
+		{&HybridTimestamp{WallNanos: 2, Logical: 1}, &HybridTimestamp{WallNanos: 2, Logical: 2}, -1},
+		{&HybridTimestamp{WallNanos: 2, Logical: 2}, &HybridTimestamp{WallNanos: 2, Logical: 1}, 1},
+		{&HybridTimestamp{WallNanos: 2, Logical: 2}, &HybridTimestamp{WallNanos: 2, Logical: 2}, 0},
+	} {
+		if got := CompareHLC(tc.a, tc.b); got != tc.want {
+			t.Errorf("CompareHLC(%v, %v) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
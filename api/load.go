@@ -0,0 +1,5 @@
+package api
+
+// LoadReportTrailer is the trailer key under which servers send a marshaled
+// LoadReport at the end of every call.
+const LoadReportTrailer = "load-report-bin"
@@ -0,0 +1,128 @@
+package chaos
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Faults of a link. The zero value forwards everything as is.
+type Faults struct {
+	// added to every chunk forwarded, in each direction
+	Latency time.Duration
+
+	// up to this much more latency, drawn per chunk
+	Jitter time.Duration
+
+	// bytes per second in each direction of a connection, 0 is unlimited
+	Bandwidth int64
+
+	// chance in [0, 1] that a new connection is reset at once
+	ResetRate float64
+
+	// swallow the bytes sent by the client
+	BlackholeUp bool
+
+	// swallow the bytes sent by the target
+	BlackholeDown bool
+}
+
+// ParseFaults parses key=value faults, e.g. latency=50ms jitter=10ms
+// bandwidth=1024 reset-rate=0.1 blackhole=up|down|both. Keys left out are
+// not faulty.
+func ParseFaults(kvs []string) (Faults, error) {
+	var f Faults
+	for _, kv := range kvs {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return f, fmt.Errorf("fault %q is not key=value", kv)
+		}
+
+		var err error
+		switch k, v := parts[0], parts[1]; k {
+		case "latency":
+			f.Latency, err = time.ParseDuration(v)
+		case "jitter":
+			f.Jitter, err = time.ParseDuration(v)
+		case "bandwidth":
+			f.Bandwidth, err = strconv.ParseInt(v, 10, 64)
+		case "reset-rate":
+			f.ResetRate, err = strconv.ParseFloat(v, 64)
+			if err == nil && (f.ResetRate < 0 || f.ResetRate > 1) {
+				err = fmt.Errorf("reset-rate %v is not in [0, 1]", v)
+			}
+		case "blackhole":
+			switch v {
+			case "up":
+				f.BlackholeUp = true
+			case "down":
+				f.BlackholeDown = true
+			case "both":
+				f.BlackholeUp, f.BlackholeDown = true, true
+			default:
+				err = fmt.Errorf("blackhole %q is not up, down or both", v)
+			}
+		default:
+			err = fmt.Errorf("unknown fault %q", k)
+		}
+		if err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+func (f Faults) String() string {
+	var kvs []string
+	if f.Latency > 0 {
+		kvs = append(kvs, "latency="+f.Latency.String())
+	}
+	if f.Jitter > 0 {
+		kvs = append(kvs, "jitter="+f.Jitter.String())
+	}
+	if f.Bandwidth > 0 {
+		kvs = append(kvs, fmt.Sprintf("bandwidth=%d", f.Bandwidth))
+	}
+	if f.ResetRate > 0 {
+		kvs = append(kvs, fmt.Sprintf("reset-rate=%v", f.ResetRate))
+	}
+	switch {
+	case f.BlackholeUp && f.BlackholeDown:
+		kvs = append(kvs, "blackhole=both")
+	case f.BlackholeUp:
+		kvs = append(kvs, "blackhole=up")
+	case f.BlackholeDown:
+		kvs = append(kvs, "blackhole=down")
+	}
+	if len(kvs) == 0 {
+		return "none"
+	}
+	return strings.Join(kvs, " ")
+}
+
+func (f Faults) Proto() *api.LinkFaults {
+	return &api.LinkFaults{
+		LatencyNanos:  int64(f.Latency),
+		JitterNanos:   int64(f.Jitter),
+		Bandwidth:     f.Bandwidth,
+		ResetRate:     f.ResetRate,
+		BlackholeUp:   f.BlackholeUp,
+		BlackholeDown: f.BlackholeDown,
+	}
+}
+
+func FaultsFromProto(p *api.LinkFaults) Faults {
+	if p == nil {
+		return Faults{}
+	}
+	return Faults{
+		Latency:       time.Duration(p.LatencyNanos),
+		Jitter:        time.Duration(p.JitterNanos),
+		Bandwidth:     p.Bandwidth,
+		ResetRate:     p.ResetRate,
+		BlackholeUp:   p.BlackholeUp,
+		BlackholeDown: p.BlackholeDown,
+	}
+}
@@ -0,0 +1,64 @@
+package chaos
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFaults(t *testing.T) {
+	for _, tc := range []struct {
+		kvs []string
+
+		// nil when the faults are invalid
+		want *Faults
+	}{
+		{nil, &Faults{}},
+		{[]string{"latency=50ms", "jitter=10ms"}, &Faults{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond}},
+		{[]string{"bandwidth=1024"}, &Faults{Bandwidth: 1024}},
+		{[]string{"reset-rate=0.1"}, &Faults{ResetRate: 0.1}},
+		{[]string{"reset-rate=1"}, &Faults{ResetRate: 1}},
+		{[]string{"blackhole=up"}, &Faults{BlackholeUp: true}},
+		{[]string{"blackhole=down"}, &Faults{BlackholeDown: true}},
+		{[]string{"blackhole=both"}, &Faults{BlackholeUp: true, BlackholeDown: true}},
+		{[]string{"latency"}, nil},
+		{[]string{"latency=fast"}, nil},
+		{[]string{"bandwidth=1k"}, nil},
+		{[]string{"reset-rate=1.5"}, nil},
+		{[]string{"reset-rate=-0.1"}, nil},
+		{[]string{"blackhole=sideways"}, nil},
+		{[]string{"loss=0.1"}, nil},
+	} {
+		got, err := ParseFaults(tc.kvs)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("ParseFaults(%q) = %v, want an error", tc.kvs, got)
+			}
+			continue
+		}
+		if err != nil || got != *tc.want {
+			t.Errorf("ParseFaults(%q) = %v, %v, want %v", tc.kvs, got, err, *tc.want)
+		}
+	}
+}
+
+// TestFaultsString checks that the faults parse back from their string.
+func TestFaultsString(t *testing.T) {
+	for _, f := range []Faults{
+		{},
+		{Latency: 50 * time.Millisecond, Jitter: time.Millisecond, Bandwidth: 10, ResetRate: 0.5},
+		{BlackholeUp: true},
+		{BlackholeUp: true, BlackholeDown: true},
+	} {
+		s := f.String()
+		if f == (Faults{}) {
+			if s != "none" {
+				t.Errorf("String() = %q, want none", s)
+			}
+			continue
+		}
+		if got, err := ParseFaults(strings.Fields(s)); err != nil || got != f {
+			t.Errorf("ParseFaults(%q) = %v, %v, want %v", s, got, err, f)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+// Package chaos proxies TCP connections to a target & injects network
+// faults into them: latency w/ jitter, a bandwidth limit, resets, blackholes
+// & one way partitions. Faults can be changed while connections are open.
+// Random choices come from RNGs derived from one seed, so that a run w/ the
+// same seed & the same connections makes the same choices.
+package chaos
+
+import (
+	"github.com/1xyz/grpc-playground/endpoint"
+	"github.com/1xyz/grpc-playground/logging"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	chunkSize = 32 * 1024
+
+	// chunks read ahead of the ones waiting for their latency
+	pendingChunks = 64
+
+	dialTimeout = 5 * time.Second
+)
+
+// Proxy forwards every connection it accepts to target, an endpoint like
+// host:port or unix:///path.
+type Proxy struct {
+	target string
+
+	seed int64
+
+	mu sync.Mutex
+
+	faults Faults
+
+	// decides which new connections are reset
+	rng *rand.Rand
+
+	lis net.Listener
+
+	conns map[*conn]bool
+
+	accepted int64
+
+	closed bool
+
+	log *logging.Logger
+}
+
+// conn is a client connection & the one to the target it is forwarded to.
+type conn struct {
+	client net.Conn
+
+	server net.Conn
+}
+
+type chunk struct {
+	b []byte
+
+	// when the chunk may be written
+	at time.Time
+}
+
+// New returns a proxy to target logging to log, the default logger when
+// nil.
+func New(target string, seed int64, log *logging.Logger) *Proxy {
+	if log == nil {
+		log = logging.Default()
+	}
+	return &Proxy{
+		target: target,
+		seed:   seed,
+		rng:    rand.New(rand.NewSource(seed)),
+		conns:  make(map[*conn]bool),
+		log:    log.With("target", target),
+	}
+}
+
+func (p *Proxy) Target() string {
+	return p.target
+}
+
+func (p *Proxy) Faults() Faults {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.faults
+}
+
+// SetFaults replaces the faults, open connections included.
+func (p *Proxy) SetFaults(f Faults) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.faults = f
+	p.log.Info("set faults", "faults", f)
+}
+
+// Conns returns the number of open & accepted connections.
+func (p *Proxy) Conns() (active int64, accepted int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return int64(len(p.conns)), p.accepted
+}
+
+// Drop closes the open connections, w/ a reset when asked, & returns how
+// many there were.
+func (p *Proxy) Drop(reset bool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for c := range p.conns {
+		if reset {
+			resetConn(c.client)
+			resetConn(c.server)
+		} else {
+			c.client.Close()
+			c.server.Close()
+		}
+	}
+	return len(p.conns)
+}
+
+// ListenAndServe listens on the endpoint addr & proxies the connections
+// until Close.
+func (p *Proxy) ListenAndServe(addr string) error {
+	lis, err := endpoint.Listen(addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(lis)
+}
+
+func (p *Proxy) Serve(lis net.Listener) error {
+	p.mu.Lock()
+	p.lis = lis
+	p.mu.Unlock()
+
+	for {
+		c, err := lis.Accept()
+		if err != nil {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.closed {
+				return nil
+			}
+			return err
+		}
+		// numbered here, in the order of accept, so that a connection gets
+		// the same RNGs in every run w/ the same seed
+		id, reset := p.admit()
+		go p.handle(c, id, reset)
+	}
+}
+
+// Close stops accepting & closes the open connections.
+func (p *Proxy) Close() {
+	p.mu.Lock()
+	p.closed = true
+	if p.lis != nil {
+		p.lis.Close()
+	}
+	p.mu.Unlock()
+	p.Drop(false)
+}
+
+// admit numbers a new connection & decides whether it is reset.
+func (p *Proxy) admit() (id int64, reset bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.accepted++
+	return p.accepted, p.faults.ResetRate > 0 && p.rng.Float64() < p.faults.ResetRate
+}
+
+func (p *Proxy) handle(client net.Conn, id int64, reset bool) {
+	if reset {
+		p.log.Debug("reset conn", "conn", id)
+		resetConn(client)
+		return
+	}
+
+	server, err := p.dial()
+	if err != nil {
+		p.log.Warn("dial target", "conn", id, "err", err)
+		resetConn(client)
+		return
+	}
+
+	c := &conn{client: client, server: server}
+	p.mu.Lock()
+	p.conns[c] = true
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.forward(server, client, rand.New(rand.NewSource(p.seed+2*id)), true)
+	}()
+	go func() {
+		defer wg.Done()
+		p.forward(client, server, rand.New(rand.NewSource(p.seed+2*id+1)), false)
+	}()
+	wg.Wait()
+
+	client.Close()
+	server.Close()
+	p.mu.Lock()
+	delete(p.conns, c)
+	p.mu.Unlock()
+}
+
+func (p *Proxy) dial() (net.Conn, error) {
+	network, address, err := endpoint.Parse(p.target)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialTimeout(network, address, dialTimeout)
+}
+
+// forward copies src to dst, up being the direction from the client. Chunks
+// are read as they arrive & written once their latency passed, in order.
+// The write half of dst is closed once src is drained.
+func (p *Proxy) forward(dst, src net.Conn, rng *rand.Rand, up bool) {
+	chunks := make(chan chunk, pendingChunks)
+	written := make(chan bool)
+	go func() {
+		defer close(written)
+		failed := false
+		for c := range chunks {
+			if failed {
+				continue
+			}
+			if d := time.Until(c.at); d > 0 {
+				time.Sleep(d)
+			}
+			if err := p.write(dst, c.b); err != nil {
+				// unblock the reader, the chunks left are dropped
+				failed = true
+				src.Close()
+				dst.Close()
+			}
+		}
+	}()
+
+	var last time.Time
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := src.Read(buf)
+		f := p.Faults()
+		if n > 0 && !(up && f.BlackholeUp) && !(!up && f.BlackholeDown) {
+			at := time.Now().Add(f.Latency)
+			if f.Jitter > 0 {
+				at = at.Add(time.Duration(rng.Int63n(int64(f.Jitter))))
+			}
+			// jitter must not reorder the stream
+			if at.Before(last) {
+				at = last
+			}
+			last = at
+			chunks <- chunk{b: append([]byte(nil), buf[:n]...), at: at}
+		}
+		if err != nil {
+			close(chunks)
+			<-written
+			if err == io.EOF {
+				closeWrite(dst)
+			} else {
+				dst.Close()
+			}
+			return
+		}
+	}
+}
+
+// write writes b to dst, paced to the bandwidth limit if there is one.
+func (p *Proxy) write(dst net.Conn, b []byte) error {
+	for len(b) > 0 {
+		bw := p.Faults().Bandwidth
+		n := len(b)
+		// slices of 50ms keep the pace smooth
+		if step := int(bw / 20); bw > 0 && n > step {
+			n = step
+			if n < 1 {
+				n = 1
+			}
+		}
+
+		if _, err := dst.Write(b[:n]); err != nil {
+			return err
+		}
+		if bw > 0 {
+			time.Sleep(time.Duration(n) * time.Second / time.Duration(bw))
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// resetConn closes c w/ a RST rather than a FIN.
+func resetConn(c net.Conn) {
+	if tc, ok := c.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	c.Close()
+}
+
+// closeWrite closes the write half of c, tcp & unix conns have one.
+func closeWrite(c net.Conn) {
+	if hc, ok := c.(interface{ CloseWrite() error }); ok {
+		hc.CloseWrite()
+		return
+	}
+	c.Close()
+}
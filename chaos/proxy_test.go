@@ -0,0 +1,214 @@
+package chaos
+
+import (
+	"github.com/1xyz/grpc-playground/endpoint"
+	"github.com/1xyz/grpc-playground/logging"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startEcho serves a target that writes back whatever it reads.
+func startEcho(t *testing.T) net.Listener {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: err = %v", err)
+	}
+	serveEcho(lis)
+	return lis
+}
+
+func serveEcho(lis net.Listener) {
+	go func() {
+		for {
+			c, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+}
+
+// startProxy proxies to a new echo target w/ faults.
+func startProxy(t *testing.T, seed int64, f Faults) (*Proxy, string, func()) {
+	target := startEcho(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: err = %v", err)
+	}
+	log, _ := logging.New(ioutil.Discard, "logfmt", logging.ErrorLevel)
+	p := New(target.Addr().String(), seed, log)
+	p.SetFaults(f)
+	go p.Serve(lis)
+	return p, lis.Addr().String(), func() {
+		p.Close()
+		target.Close()
+	}
+}
+
+// roundTrip sends msg through c & returns what came back before timeout.
+func roundTrip(c net.Conn, msg string, timeout time.Duration) (string, error) {
+	if _, err := c.Write([]byte(msg)); err != nil {
+		return "", err
+	}
+	c.SetReadDeadline(time.Now().Add(timeout))
+	b := make([]byte, len(msg))
+	n, err := io.ReadFull(c, b)
+	return string(b[:n]), err
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func isReset(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "connection reset")
+}
+
+func TestProxyForwards(t *testing.T) {
+	_, addr, stop := startProxy(t, 1, Faults{Latency: 20 * time.Millisecond})
+	defer stop()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: err = %v", err)
+	}
+	defer c.Close()
+
+	start := time.Now()
+	if got, err := roundTrip(c, "hello", 5*time.Second); err != nil || got != "hello" {
+		t.Fatalf("round trip = %q, err = %v, want hello", got, err)
+	}
+	if took := time.Since(start); took < 40*time.Millisecond {
+		t.Fatalf("round trip took %v, want the latency both ways", took)
+	}
+}
+
+func TestProxyUnixTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chaos")
+	if err != nil {
+		t.Fatalf("temp dir: err = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	target := "unix://" + filepath.Join(dir, "echo.sock")
+	tlis, err := endpoint.Listen(target)
+	if err != nil {
+		t.Fatalf("listen: err = %v", err)
+	}
+	defer tlis.Close()
+	serveEcho(tlis)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: err = %v", err)
+	}
+	log, _ := logging.New(ioutil.Discard, "logfmt", logging.ErrorLevel)
+	p := New(target, 1, log)
+	defer p.Close()
+	go p.Serve(lis)
+
+	c, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: err = %v", err)
+	}
+	defer c.Close()
+	if got, err := roundTrip(c, "hello", 5*time.Second); err != nil || got != "hello" {
+		t.Fatalf("round trip = %q, err = %v, want hello", got, err)
+	}
+}
+
+func TestProxyBlackhole(t *testing.T) {
+	for _, f := range []Faults{{BlackholeUp: true}, {BlackholeDown: true}} {
+		p, addr, stop := startProxy(t, 1, f)
+
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("%v: dial: err = %v", f, err)
+		}
+		if got, err := roundTrip(c, "hello", 200*time.Millisecond); !isTimeout(err) {
+			t.Fatalf("%v: round trip = %q, err = %v, want a timeout", f, got, err)
+		}
+
+		// the connection stays open & forwards again once the fault is
+		// lifted, the bytes swallowed meanwhile are lost
+		p.SetFaults(Faults{})
+		if got, err := roundTrip(c, "again", 5*time.Second); err != nil || got != "again" {
+			t.Fatalf("%v: round trip after the fault = %q, err = %v", f, got, err)
+		}
+		c.Close()
+		stop()
+	}
+}
+
+// resets dials n connections one after the other & returns which the proxy
+// reset.
+func resets(t *testing.T, addr string, n int) []bool {
+	var reset []bool
+	for i := 0; i < n; i++ {
+		// the reset may come before the dial returns
+		c, err := net.Dial("tcp", addr)
+		if err != nil && !isReset(err) {
+			t.Fatalf("conn %d: dial: err = %v", i, err)
+		}
+		if err != nil {
+			reset = append(reset, true)
+			continue
+		}
+		got, err := roundTrip(c, "hello", 5*time.Second)
+		switch {
+		case err == nil && got == "hello":
+			reset = append(reset, false)
+		case isReset(err):
+			reset = append(reset, true)
+		default:
+			t.Fatalf("conn %d: round trip = %q, err = %v, want hello or a reset", i, got, err)
+		}
+		c.Close()
+	}
+	return reset
+}
+
+func TestProxyReset(t *testing.T) {
+	_, addr, stop := startProxy(t, 1, Faults{ResetRate: 1})
+	defer stop()
+
+	for i, r := range resets(t, addr, 3) {
+		if !r {
+			t.Fatalf("conn %d was not reset, want all w/ a reset-rate of 1", i)
+		}
+	}
+}
+
+// TestProxySeed checks that proxies w/ the same seed reset the same
+// connections.
+func TestProxySeed(t *testing.T) {
+	var runs [2][]bool
+	for i := range runs {
+		_, addr, stop := startProxy(t, 42, Faults{ResetRate: 0.5})
+		runs[i] = resets(t, addr, 20)
+		stop()
+	}
+
+	n := 0
+	for i := range runs[0] {
+		if runs[0][i] != runs[1][i] {
+			t.Fatalf("conn %d reset = %v in one run & %v in the other", i, runs[0][i], runs[1][i])
+		}
+		if runs[0][i] {
+			n++
+		}
+	}
+	if n == 0 || n == len(runs[0]) {
+		t.Fatalf("%d of %d conns reset, want some w/ a reset-rate of 0.5", n, len(runs[0]))
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/docopt/docopt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"os"
+	"text/tabwriter"
+)
+
+func printRateLimits(resp *api.RateLimitsResponse) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tRATE\tBURST\t")
+	for _, l := range resp.Limits {
+		fmt.Fprintf(w, "%s\t%.2f/s\t%d\t\n", l.Method, l.Rate, l.Burst)
+	}
+	fmt.Fprintln(w, "\t\t\t")
+	fmt.Fprintln(w, "KEY\tMETHOD\tTOKENS\tALLOWED\tLIMITED\t")
+	for _, b := range resp.Buckets {
+		fmt.Fprintf(w, "%s\t%s\t%.2f\t%d\t%d\t\n", b.Key, b.Method, b.Tokens, b.Allowed, b.Limited)
+	}
+	w.Flush()
+}
+
+func printLoad(r *api.LoadReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVER ID\tIN FLIGHT\tCPU\tSTREAMS\tQUEUED\t")
+	fmt.Fprintf(w, "%s\t%d\t%.1f%%\t%d\t%d\t\n",
+		r.ServerId, r.InFlight, 100*r.CpuUtilization, r.ActiveStreams, r.QueueDepth)
+	w.Flush()
+}
+
+func printConfigChanges(resp *api.ReloadConfigResponse) {
+	if len(resp.Changes) == 0 {
+		fmt.Println("no changes")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tFROM\tTO\t")
+	for _, c := range resp.Changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t\n", c.Setting, c.From, c.To)
+	}
+	w.Flush()
+}
+
+func runAdmin(cli *echoClient, argv []string) {
+	usage := `usage: client admin (ratelimits | load) [--timeout=<timeout>]
+       client admin loglevel [<level>] [--timeout=<timeout>]
+       client admin reload [--timeout=<timeout>]
+
+options:
+   --timeout=<timeout>    Call timeout [default: 5s].
+
+the admin calls go to every server in --servers. loglevel prints the log level
+of each server, or sets it to debug, info, warn or error. reload makes each
+server read its config again & prints the settings changed.
+`
+	parser := &docopt.Parser{}
+	args, err := parser.ParseArgs(usage, argv, "1.0")
+	if err != nil {
+		logger.Error("invalid arguments", "err", err)
+		return
+	}
+
+	timeout, err := parseDuration(args, "--timeout")
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+
+	for _, addr := range cli.servers {
+		conn, err := grpc.Dial(addr, grpc.WithInsecure())
+		if err != nil {
+			logger.Error("did not connect", "err", err)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		c := api.NewAdminClient(conn)
+		fmt.Printf("== %s\n", addr)
+		switch {
+		case args["ratelimits"].(bool):
+			resp, err := c.GetRateLimits(ctx, &api.Empty{})
+			if err != nil {
+				logger.Error("call failed", "addr", addr, "err", err)
+				break
+			}
+			printRateLimits(resp)
+
+		case args["load"].(bool):
+			resp, err := c.GetLoad(ctx, &api.Empty{})
+			if err != nil {
+				logger.Error("call failed", "addr", addr, "err", err)
+				break
+			}
+			printLoad(resp)
+
+		case args["loglevel"].(bool):
+			var resp *api.LogLevel
+			if level, ok := args["<level>"].(string); ok {
+				resp, err = c.SetLogLevel(ctx, &api.LogLevel{Level: level})
+			} else {
+				resp, err = c.GetLogLevel(ctx, &api.Empty{})
+			}
+			if err != nil {
+				logger.Error("call failed", "addr", addr, "err", err)
+				break
+			}
+			fmt.Printf("log level = %v\n", resp.Level)
+
+		case args["reload"].(bool):
+			resp, err := c.ReloadConfig(ctx, &api.Empty{})
+			if err != nil {
+				logger.Error("call failed", "addr", addr, "err", err)
+				break
+			}
+			printConfigChanges(resp)
+		}
+
+		cancel()
+		conn.Close()
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/serviceconfig"
+	"time"
+)
+
+// lbServiceConfig returns a service config that balances using policy,
+// configured w/ the JSON object config. With healthCheck only servers that
+// report SERVING are picked, w/ retry calls are retried per retryPolicy.
+func lbServiceConfig(policy string, config string, healthCheck bool, retry bool) (string, error) {
+	if !json.Valid([]byte(config)) {
+		return "", fmt.Errorf("invalid %v config %q", policy, config)
+	}
+
+	sc := map[string]interface{}{
+		"loadBalancingConfig": []map[string]json.RawMessage{{policy: json.RawMessage(config)}},
+	}
+	if healthCheck {
+		sc["healthCheckConfig"] = map[string]string{"serviceName": ""}
+	}
+	if retry {
+		var rp map[string]interface{}
+		if err := json.Unmarshal([]byte(retryPolicy), &rp); err != nil {
+			return "", err
+		}
+		sc["methodConfig"] = rp["methodConfig"]
+	}
+
+	b, err := json.Marshal(sc)
+	return string(b), err
+}
+
+// configurablePickerBuilder is a picker builder that takes the balancer
+// config of its policy from the service config.
+type configurablePickerBuilder interface {
+	base.V2PickerBuilder
+
+	UpdateConfig(cfg serviceconfig.LoadBalancingConfig)
+}
+
+// policyBuilder builds balancers on top of the base balancer, which takes
+// care of subconns & health checking, while the picker builder decides how
+// a ready subconn is picked. Unlike the base builder it parses the policy's
+// config out of the service config & hands it to the picker builder.
+type policyBuilder struct {
+	name string
+
+	newPickerBuilder func() configurablePickerBuilder
+
+	parseConfig func(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error)
+}
+
+func (p *policyBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := p.newPickerBuilder()
+	b := base.NewBalancerBuilderV2(p.name, pb, base.Config{HealthCheck: true}).Build(cc, opts)
+	return &policyBalancer{Balancer: b, v2: b.(balancer.V2Balancer), pb: pb}
+}
+
+func (p *policyBuilder) Name() string {
+	return p.name
+}
+
+func (p *policyBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	return p.parseConfig(js)
+}
+
+type policyBalancer struct {
+	balancer.Balancer
+
+	v2 balancer.V2Balancer
+
+	pb configurablePickerBuilder
+}
+
+func (p *policyBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	if s.BalancerConfig != nil {
+		p.pb.UpdateConfig(s.BalancerConfig)
+	}
+	return p.v2.UpdateClientConnState(s)
+}
+
+func (p *policyBalancer) ResolverError(err error) {
+	p.v2.ResolverError(err)
+}
+
+func (p *policyBalancer) UpdateSubConnState(sc balancer.SubConn, s balancer.SubConnState) {
+	p.v2.UpdateSubConnState(sc, s)
+}
+
+// duration is a time.Duration that reads from JSON strings like "1.5s".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/docopt/docopt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"math/bits"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+type benchConfig struct {
+	concurrency int
+
+	// target rate for open loop runs, 0 runs closed loop
+	qps float64
+
+	duration time.Duration
+
+	// results of calls started during warm-up are dropped
+	warmup time.Duration
+
+	timeout time.Duration
+
+	mix []benchWeight
+}
+
+type benchWeight struct {
+	method string
+
+	weight int
+}
+
+// parseMix parses a method mix like "Echo=8,FailingEcho=1,StreamEcho=1".
+// At least one weight must be above 0.
+func parseMix(s string) ([]benchWeight, error) {
+	mix := make([]benchWeight, 0)
+	total := 0
+	for _, e := range strings.Split(s, ",") {
+		kv := strings.SplitN(e, "=", 2)
+		w := 1
+		if len(kv) == 2 {
+			var err error
+			if w, err = strconv.Atoi(kv[1]); err != nil || w < 0 {
+				return nil, fmt.Errorf("invalid weight in %q", e)
+			}
+		}
+
+		switch kv[0] {
+		case "Echo", "FailingEcho", "StreamEcho":
+		default:
+			return nil, fmt.Errorf("unknown method %q", kv[0])
+		}
+		mix = append(mix, benchWeight{method: kv[0], weight: w})
+		total += w
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("mix %q has no weight above 0", s)
+	}
+	return mix, nil
+}
+
+func pickMethod(mix []benchWeight, rnd *rand.Rand) string {
+	total := 0
+	for _, m := range mix {
+		total += m.weight
+	}
+	n := rnd.Intn(total)
+	for _, m := range mix {
+		if n < m.weight {
+			return m.method
+		}
+		n -= m.weight
+	}
+	return mix[len(mix)-1].method
+}
+
+type benchResult struct {
+	method string
+
+	start time.Time
+
+	latency time.Duration
+
+	code codes.Code
+
+	serverId string
+}
+
+// benchStats aggregates the results of one method, or of the whole run.
+type benchStats struct {
+	latencies []time.Duration
+
+	codes map[codes.Code]int
+
+	servers map[string]int
+}
+
+func newBenchStats() *benchStats {
+	return &benchStats{
+		codes:   make(map[codes.Code]int),
+		servers: make(map[string]int),
+	}
+}
+
+func (b *benchStats) add(r benchResult) {
+	b.latencies = append(b.latencies, r.latency)
+	b.codes[r.code]++
+	if r.serverId != "" {
+		b.servers[r.serverId]++
+	}
+}
+
+func (b *benchStats) errors() int {
+	return len(b.latencies) - b.codes[codes.OK]
+}
+
+// histogram buckets latencies into power of two microsecond buckets; bucket
+// i holds latencies in [2^(i-1), 2^i) µs.
+func (b *benchStats) histogram() []int {
+	h := make([]int, 0)
+	for _, l := range b.latencies {
+		i := bits.Len64(uint64(l.Microseconds()))
+		for len(h) <= i {
+			h = append(h, 0)
+		}
+		h[i]++
+	}
+	return h
+}
+
+func bucketBounds(i int) (time.Duration, time.Duration) {
+	if i == 0 {
+		return 0, time.Microsecond
+	}
+	return time.Duration(1<<uint(i-1)) * time.Microsecond, time.Duration(1<<uint(i)) * time.Microsecond
+}
+
+type benchRunner struct {
+	cfg benchConfig
+
+	c api.EchoClient
+
+	clientId string
+}
+
+func (b *benchRunner) call(method string) benchResult {
+	ctx, cancel := context.WithTimeout(context.Background(), b.cfg.timeout)
+	defer cancel()
+
+	r := benchResult{method: method}
+	req := &api.EchoRequest{ClientId: b.clientId}
+	var resp *api.EchoResponse
+	var err error
+	switch method {
+	case "Echo":
+		resp, err = b.c.Echo(ctx, req)
+	case "FailingEcho":
+		resp, err = b.c.FailingEcho(ctx, req)
+	case "StreamEcho":
+		// a stream is timed up to its first message
+		var stream api.Echo_StreamEchoClient
+		if stream, err = b.c.StreamEcho(ctx, req); err == nil {
+			resp, err = stream.Recv()
+			if err == io.EOF {
+				err = status.Error(codes.Unknown, "stream closed w/o a message")
+			}
+		}
+	}
+
+	r.code = status.Code(err)
+	if resp != nil {
+		r.serverId = resp.ServerId
+	}
+	return r
+}
+
+// Run drives the load and returns the results of calls started after the
+// warm-up.
+//
+// Open loop runs issue calls on a fixed schedule of qps and time each call
+// from the moment it was scheduled, not from when a worker got to it. A
+// stalled server then shows up as latency of every call that queued behind
+// it, which corrects for coordinated omission. Closed loop runs have each
+// worker issue its next call as soon as the previous one completes.
+func (b *benchRunner) Run() []benchResult {
+	start := time.Now()
+	end := start.Add(b.cfg.warmup + b.cfg.duration)
+
+	var schedule chan time.Time
+	if b.cfg.qps > 0 {
+		schedule = make(chan time.Time, b.cfg.concurrency)
+		interval := time.Duration(float64(time.Second) / b.cfg.qps)
+		go func() {
+			defer close(schedule)
+			for next := start; next.Before(end); next = next.Add(interval) {
+				if d := time.Until(next); d > 0 {
+					time.Sleep(d)
+				}
+				schedule <- next
+			}
+		}()
+	}
+
+	resultCh := make(chan benchResult, b.cfg.concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < b.cfg.concurrency; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for {
+				var t time.Time
+				if schedule != nil {
+					var ok bool
+					if t, ok = <-schedule; !ok {
+						return
+					}
+				} else if t = time.Now(); !t.Before(end) {
+					return
+				}
+
+				r := b.call(pickMethod(b.cfg.mix, rnd))
+				r.start = t
+				r.latency = time.Since(t)
+				resultCh <- r
+			}
+		}(start.UnixNano() + int64(i))
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	measureFrom := start.Add(b.cfg.warmup)
+	results := make([]benchResult, 0)
+	for r := range resultCh {
+		if !r.start.Before(measureFrom) {
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+// benchReport is the summary of a run, w/ latencies in microseconds.
+type benchReport struct {
+	Mode        string                  `json:"mode"`
+	Concurrency int                     `json:"concurrency"`
+	TargetQPS   float64                 `json:"target_qps,omitempty"`
+	Duration    string                  `json:"duration"`
+	QPS         float64                 `json:"qps"`
+	Methods     map[string]*methodStats `json:"methods"`
+	Total       *methodStats            `json:"total"`
+	Histogram   []histogramBucket       `json:"histogram"`
+}
+
+type methodStats struct {
+	Count     int            `json:"count"`
+	Errors    int            `json:"errors"`
+	MinUs     int64          `json:"min_us"`
+	P50Us     int64          `json:"p50_us"`
+	P90Us     int64          `json:"p90_us"`
+	P99Us     int64          `json:"p99_us"`
+	P999Us    int64          `json:"p999_us"`
+	MaxUs     int64          `json:"max_us"`
+	MeanUs    int64          `json:"mean_us"`
+	Codes     map[string]int `json:"codes"`
+	ServerIds map[string]int `json:"server_ids"`
+}
+
+type histogramBucket struct {
+	FromUs int64 `json:"from_us"`
+	ToUs   int64 `json:"to_us"`
+	Count  int   `json:"count"`
+}
+
+func summarize(s *benchStats) *methodStats {
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	m := &methodStats{
+		Count:     len(s.latencies),
+		Errors:    s.errors(),
+		Codes:     make(map[string]int),
+		ServerIds: s.servers,
+	}
+	for c, n := range s.codes {
+		m.Codes[c.String()] = n
+	}
+	if m.Count == 0 {
+		return m
+	}
+
+	var sum time.Duration
+	for _, l := range s.latencies {
+		sum += l
+	}
+	m.MinUs = s.latencies[0].Microseconds()
+	m.P50Us = percentile(s.latencies, 50).Microseconds()
+	m.P90Us = percentile(s.latencies, 90).Microseconds()
+	m.P99Us = percentile(s.latencies, 99).Microseconds()
+	m.P999Us = percentile(s.latencies, 99.9).Microseconds()
+	m.MaxUs = s.latencies[len(s.latencies)-1].Microseconds()
+	m.MeanUs = (sum / time.Duration(m.Count)).Microseconds()
+	return m
+}
+
+func newBenchReport(cfg benchConfig, results []benchResult) *benchReport {
+	rep := &benchReport{
+		Mode:        "closed-loop",
+		Concurrency: cfg.concurrency,
+		TargetQPS:   cfg.qps,
+		Duration:    cfg.duration.String(),
+		QPS:         float64(len(results)) / cfg.duration.Seconds(),
+		Methods:     make(map[string]*methodStats),
+	}
+	if cfg.qps > 0 {
+		rep.Mode = "open-loop"
+	}
+
+	total := newBenchStats()
+	byMethod := make(map[string]*benchStats)
+	for _, r := range results {
+		if byMethod[r.method] == nil {
+			byMethod[r.method] = newBenchStats()
+		}
+		byMethod[r.method].add(r)
+		total.add(r)
+	}
+
+	for name, s := range byMethod {
+		rep.Methods[name] = summarize(s)
+	}
+	rep.Total = summarize(total)
+	for i, n := range total.histogram() {
+		if n == 0 {
+			continue
+		}
+		from, to := bucketBounds(i)
+		rep.Histogram = append(rep.Histogram, histogramBucket{
+			FromUs: from.Microseconds(),
+			ToUs:   to.Microseconds(),
+			Count:  n,
+		})
+	}
+	return rep
+}
+
+func (rep *benchReport) WriteText(out io.Writer) {
+	fmt.Fprintf(out, "mode = %s concurrency = %d duration = %s qps = %.1f",
+		rep.Mode, rep.Concurrency, rep.Duration, rep.QPS)
+	if rep.TargetQPS > 0 {
+		fmt.Fprintf(out, " (target %.1f)", rep.TargetQPS)
+	}
+	fmt.Fprintf(out, "\n\n")
+
+	us := func(v int64) time.Duration { return time.Duration(v) * time.Microsecond }
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "METHOD\tCOUNT\tERRORS\tMIN\tP50\tP90\tP99\tP99.9\tMAX\tMEAN\t")
+	names := make([]string, 0)
+	for name := range rep.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	row := func(name string, m *methodStats) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t\n", name, m.Count, m.Errors,
+			us(m.MinUs), us(m.P50Us), us(m.P90Us), us(m.P99Us), us(m.P999Us), us(m.MaxUs), us(m.MeanUs))
+	}
+	for _, name := range names {
+		row(name, rep.Methods[name])
+	}
+	row("total", rep.Total)
+	w.Flush()
+
+	fmt.Fprintf(out, "\nstatus codes:\n")
+	printCounts(out, rep.Total.Codes, rep.Total.Count)
+
+	fmt.Fprintf(out, "\nserver ids:\n")
+	ok := 0
+	for _, n := range rep.Total.ServerIds {
+		ok += n
+	}
+	printCounts(out, rep.Total.ServerIds, ok)
+
+	fmt.Fprintf(out, "\nlatency histogram:\n")
+	max := 0
+	for _, b := range rep.Histogram {
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+	for _, b := range rep.Histogram {
+		bar := strings.Repeat("#", (b.Count*40+max-1)/max)
+		fmt.Fprintf(out, "  [%10v, %10v) %8d %s\n", us(b.FromUs), us(b.ToUs), b.Count, bar)
+	}
+}
+
+func printCounts(out io.Writer, counts map[string]int, total int) {
+	keys := make([]string, 0)
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return counts[keys[i]] > counts[keys[j]] })
+	for _, k := range keys {
+		fmt.Fprintf(out, "  %-40s %8d %6.2f%%\n", k, counts[k], 100*float64(counts[k])/float64(total))
+	}
+}
+
+func runBench(cli *echoClient, argv []string) {
+	usage := `usage: client bench [--concurrency=<n>] [--qps=<qps>] [--duration=<duration>] [--warmup=<warmup>]
+                    [--mix=<mix>] [--timeout=<timeout>] [--format=<format>] [--retry] [--health-check]
+
+options:
+   --concurrency=<n>        Concurrent workers [default: 10].
+   --qps=<qps>              Open loop target rate, 0 runs closed loop [default: 0].
+   --duration=<duration>    Measured duration [default: 10s].
+   --warmup=<warmup>        Warm-up excluded from the report [default: 2s].
+   --mix=<mix>              Weighted method mix of Echo, FailingEcho & StreamEcho [default: Echo=1].
+   --timeout=<timeout>      Per call timeout [default: 5s].
+   --format=<format>        Report as text or json [default: text].
+   --retry                  Retry Unavailable calls per the client's retry policy.
+   --health-check           Only send calls to servers reporting SERVING.
+
+calls are spread over all servers by the --balancer policy.
+`
+	parser := &docopt.Parser{}
+	args, err := parser.ParseArgs(usage, argv, "1.0")
+	if err != nil {
+		logger.Error("invalid arguments", "err", err)
+		return
+	}
+
+	cfg := benchConfig{}
+	if cfg.concurrency, err = args.Int("--concurrency"); err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+	if cfg.qps, err = args.Float64("--qps"); err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+	for key, d := range map[string]*time.Duration{
+		"--duration": &cfg.duration,
+		"--warmup":   &cfg.warmup,
+		"--timeout":  &cfg.timeout,
+	} {
+		if *d, err = parseDuration(args, key); err != nil {
+			logger.Error("command failed", "err", err)
+			return
+		}
+	}
+	mix, _ := args.String("--mix")
+	if cfg.mix, err = parseMix(mix); err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+	format, _ := args.String("--format")
+	retry, _ := args.Bool("--retry")
+	healthCheck, _ := args.Bool("--health-check")
+
+	config, err := lbServiceConfig(cli.balancer, cli.balancerConfig, healthCheck, retry)
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+
+	conn, cleanup, err := dialServers(cli.servers, config, grpc.WithBlock())
+	if err != nil {
+		logger.Error("did not connect", "err", err)
+		return
+	}
+	defer cleanup()
+	defer conn.Close()
+
+	b := &benchRunner{cfg: cfg, c: api.NewEchoClient(conn), clientId: cli.clientId}
+	logger.Info("bench started", "warmup", cfg.warmup, "duration", cfg.duration)
+	rep := newBenchReport(cfg, b.Run())
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			logger.Error("command failed", "err", err)
+		}
+	default:
+		rep.WriteText(os.Stdout)
+	}
+}
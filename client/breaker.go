@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const breakerPolicy = "circuit_breaker"
+
+func init() {
+	balancer.Register(&policyBuilder{
+		name: breakerPolicy,
+		newPickerBuilder: func() configurablePickerBuilder {
+			return &breakerPickerBuilder{cfg: defaultBreakerConfig(), breakers: make(map[string]*circuitBreaker)}
+		},
+		parseConfig: parseBreakerConfig,
+	})
+}
+
+// breakerConfig is the loadBalancingConfig of the circuit_breaker policy.
+type breakerConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	// fraction of failed calls in the window that opens the circuit
+	ErrorThreshold float64 `json:"errorThreshold"`
+
+	// number of most recent calls the error rate is taken over
+	Window int `json:"window"`
+
+	// calls needed in the window before the circuit can open
+	MinRequests int `json:"minRequests"`
+
+	// how long an open circuit rejects calls before probing
+	OpenTimeout duration `json:"openTimeout"`
+
+	// successful probes needed in half-open to close the circuit, this many
+	// probes may be in flight at once
+	HalfOpenProbes int `json:"halfOpenProbes"`
+}
+
+func defaultBreakerConfig() *breakerConfig {
+	return &breakerConfig{
+		ErrorThreshold: 0.5,
+		Window:         20,
+		MinRequests:    10,
+		OpenTimeout:    duration(5 * time.Second),
+		HalfOpenProbes: 3,
+	}
+}
+
+func parseBreakerConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := defaultBreakerConfig()
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case cfg.ErrorThreshold <= 0 || cfg.ErrorThreshold > 1:
+		return nil, fmt.Errorf("errorThreshold = %v must be in (0, 1]", cfg.ErrorThreshold)
+	case cfg.Window < 1:
+		return nil, fmt.Errorf("window = %v must be positive", cfg.Window)
+	case cfg.MinRequests < 1 || cfg.MinRequests > cfg.Window:
+		return nil, fmt.Errorf("minRequests = %v must be in [1, window]", cfg.MinRequests)
+	case cfg.OpenTimeout <= 0:
+		return nil, fmt.Errorf("openTimeout must be positive")
+	case cfg.HalfOpenProbes < 1:
+		return nil, fmt.Errorf("halfOpenProbes = %v must be positive", cfg.HalfOpenProbes)
+	}
+	return cfg, nil
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "CLOSED"
+	case breakerOpen:
+		return "OPEN"
+	case breakerHalfOpen:
+		return "HALF_OPEN"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// isBackendFailure tells if err counts against the backend. Errors caused by
+// the caller, like a cancelled context, do not.
+func isBackendFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown,
+		codes.ResourceExhausted, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
+}
+
+// circuitBreaker tracks the outcome of calls to one backend address.
+//
+// A closed circuit lets every call through and opens once the error rate
+// over the last window calls reaches the threshold. An open circuit rejects
+// calls until openTimeout passes, then goes half-open and lets a few probe
+// calls through. Enough successful probes close it, any failed probe opens
+// it again.
+type circuitBreaker struct {
+	mu sync.Mutex
+
+	addr string
+
+	cfg *breakerConfig
+
+	state breakerState
+
+	// ring of the last calls, true for a failure
+	outcomes []bool
+
+	next int
+
+	failures int
+
+	openedAt time.Time
+
+	probesInFlight int
+
+	probeSuccesses int
+}
+
+func newCircuitBreaker(addr string, cfg *breakerConfig) *circuitBreaker {
+	return &circuitBreaker{addr: addr, cfg: cfg}
+}
+
+func (c *circuitBreaker) setConfig(cfg *breakerConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg = cfg
+	c.resetWindow()
+}
+
+// allow tells if a call may go to this backend, probe tells if that call
+// is a half-open probe.
+func (c *circuitBreaker) allow(now time.Time) (ok bool, probe bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case breakerClosed:
+		return true, false
+
+	case breakerOpen:
+		if now.Sub(c.openedAt) < time.Duration(c.cfg.OpenTimeout) {
+			return false, false
+		}
+		c.transition(breakerHalfOpen, "open timeout elapsed")
+		fallthrough
+
+	default:
+		if c.probesInFlight >= c.cfg.HalfOpenProbes {
+			return false, false
+		}
+		c.probesInFlight++
+		return true, true
+	}
+}
+
+func (c *circuitBreaker) record(err error, probe bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	failed := isBackendFailure(err)
+	if probe {
+		c.probesInFlight--
+		if c.state != breakerHalfOpen {
+			return
+		}
+
+		if failed {
+			c.trip(fmt.Sprintf("probe failed: %v", status.Code(err)))
+			return
+		}
+		if c.probeSuccesses++; c.probeSuccesses >= c.cfg.HalfOpenProbes {
+			c.transition(breakerClosed, fmt.Sprintf("%d probes succeeded", c.probeSuccesses))
+			c.resetWindow()
+		}
+		return
+	}
+
+	if c.state != breakerClosed {
+		return
+	}
+
+	if len(c.outcomes) < c.cfg.Window {
+		c.outcomes = append(c.outcomes, failed)
+	} else {
+		if c.outcomes[c.next] {
+			c.failures--
+		}
+		c.outcomes[c.next] = failed
+		c.next = (c.next + 1) % c.cfg.Window
+	}
+	if failed {
+		c.failures++
+	}
+
+	if len(c.outcomes) >= c.cfg.MinRequests && c.errorRate() >= c.cfg.ErrorThreshold {
+		c.trip(fmt.Sprintf("error rate = %.2f over %d calls", c.errorRate(), len(c.outcomes)))
+	}
+}
+
+func (c *circuitBreaker) errorRate() float64 {
+	if len(c.outcomes) == 0 {
+		return 0
+	}
+	return float64(c.failures) / float64(len(c.outcomes))
+}
+
+func (c *circuitBreaker) trip(reason string) {
+	c.openedAt = time.Now()
+	c.resetWindow()
+	c.transition(breakerOpen, reason)
+}
+
+func (c *circuitBreaker) resetWindow() {
+	c.outcomes = c.outcomes[:0]
+	c.next = 0
+	c.failures = 0
+	c.probeSuccesses = 0
+}
+
+func (c *circuitBreaker) transition(to breakerState, reason string) {
+	logger.Info("breaker state changed", "component", "breaker", "addr", c.addr, "from", c.state, "to", to, "reason", reason)
+	c.state = to
+}
+
+// breakerPickerBuilder keeps a circuit breaker per backend address across
+// the pickers it builds.
+type breakerPickerBuilder struct {
+	mu sync.Mutex
+
+	cfg *breakerConfig
+
+	breakers map[string]*circuitBreaker
+}
+
+func (b *breakerPickerBuilder) UpdateConfig(cfg serviceconfig.LoadBalancingConfig) {
+	c, ok := cfg.(*breakerConfig)
+	if !ok {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cfg = c
+	for _, cb := range b.breakers {
+		cb.setConfig(c)
+	}
+}
+
+func (b *breakerPickerBuilder) Build(info base.PickerBuildInfo) balancer.V2Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPickerV2(balancer.ErrNoSubConnAvailable)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p := &breakerPicker{}
+	for sc, sci := range info.ReadySCs {
+		addr := sci.Address.Addr
+		cb, ok := b.breakers[addr]
+		if !ok {
+			cb = newCircuitBreaker(addr, b.cfg)
+			b.breakers[addr] = cb
+		}
+		p.subConns = append(p.subConns, sc)
+		p.breakers = append(p.breakers, cb)
+	}
+	return p
+}
+
+// breakerPicker round robins over the ready subconns, skipping those whose
+// circuit is open. When every circuit is open the call fails right away
+// rather than waiting on a backend that is known to be failing.
+type breakerPicker struct {
+	subConns []balancer.SubConn
+
+	breakers []*circuitBreaker
+
+	next uint32
+}
+
+func (p *breakerPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	now := time.Now()
+	n := uint32(len(p.subConns))
+	start := atomic.AddUint32(&p.next, 1)
+	for i := uint32(0); i < n; i++ {
+		idx := (start + i) % n
+		cb := p.breakers[idx]
+		ok, probe := cb.allow(now)
+		if !ok {
+			continue
+		}
+
+		return balancer.PickResult{
+			SubConn: p.subConns[idx],
+			Done: func(di balancer.DoneInfo) {
+				cb.record(di.Err, probe)
+			},
+		}, nil
+	}
+
+	return balancer.PickResult{}, status.Errorf(codes.Unavailable,
+		"circuit open for all %d backends", n)
+}
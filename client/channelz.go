@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"github.com/docopt/docopt-go"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/grpc/channelz/service"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// number of connectivity transitions shown per channel or subchannel
+const channelzTransitions = 3
+
+// serveChannelz exposes the channelz data of this process on addr, so the
+// client's channels & subchannels can be inspected while it runs.
+func serveChannelz(addr string) (*grpc.Server, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := grpc.NewServer()
+	service.RegisterChannelzServiceToServer(s)
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			logger.Error("serve channelz", "err", err)
+		}
+	}()
+
+	logger.Info("serving channelz", "addr", lis.Addr())
+	return s, nil
+}
+
+// channelzView renders the channelz state of a process in a tree of
+// channels -> subchannels -> sockets, and servers -> sockets.
+type channelzView struct {
+	c channelzpb.ChannelzClient
+
+	w io.Writer
+}
+
+func (v *channelzView) Print(ctx context.Context) error {
+	top, err := v.c.GetTopChannels(ctx, &channelzpb.GetTopChannelsRequest{})
+	if err != nil {
+		return err
+	}
+	for _, ch := range top.Channel {
+		if err := v.printChannel(ctx, ch, ""); err != nil {
+			return err
+		}
+	}
+
+	servers, err := v.c.GetServers(ctx, &channelzpb.GetServersRequest{})
+	if err != nil {
+		return err
+	}
+	for _, s := range servers.Server {
+		if err := v.printServer(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *channelzView) printChannel(ctx context.Context, ch *channelzpb.Channel, indent string) error {
+	d := ch.Data
+	fmt.Fprintf(v.w, "%schannel %d target=%s state=%v calls=%s last_call=%s\n",
+		indent, ch.Ref.ChannelId, d.Target, d.State.GetState(),
+		calls(d.CallsStarted, d.CallsSucceeded, d.CallsFailed), ts(d.LastCallStartedTimestamp))
+	v.printTransitions(d.Trace, indent+"  ")
+
+	for _, ref := range ch.ChannelRef {
+		resp, err := v.c.GetChannel(ctx, &channelzpb.GetChannelRequest{ChannelId: ref.ChannelId})
+		if err != nil {
+			return err
+		}
+		if err := v.printChannel(ctx, resp.Channel, indent+"  "); err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range ch.SubchannelRef {
+		resp, err := v.c.GetSubchannel(ctx, &channelzpb.GetSubchannelRequest{SubchannelId: ref.SubchannelId})
+		if err != nil {
+			return err
+		}
+		if err := v.printSubchannel(ctx, resp.Subchannel, indent+"  "); err != nil {
+			return err
+		}
+	}
+
+	return v.printSockets(ctx, ch.SocketRef, indent+"  ")
+}
+
+func (v *channelzView) printSubchannel(ctx context.Context, sc *channelzpb.Subchannel, indent string) error {
+	d := sc.Data
+	fmt.Fprintf(v.w, "%ssubchannel %d addr=%s state=%v calls=%s last_call=%s\n",
+		indent, sc.Ref.SubchannelId, subchannelAddr(d.Trace), d.State.GetState(),
+		calls(d.CallsStarted, d.CallsSucceeded, d.CallsFailed), ts(d.LastCallStartedTimestamp))
+	v.printTransitions(d.Trace, indent+"  ")
+	return v.printSockets(ctx, sc.SocketRef, indent+"  ")
+}
+
+func (v *channelzView) printServer(ctx context.Context, s *channelzpb.Server) error {
+	d := s.Data
+	fmt.Fprintf(v.w, "server %d calls=%s last_call=%s\n",
+		s.Ref.ServerId, calls(d.CallsStarted, d.CallsSucceeded, d.CallsFailed), ts(d.LastCallStartedTimestamp))
+	for _, ref := range s.ListenSocket {
+		fmt.Fprintf(v.w, "  listen socket %d %s\n", ref.SocketId, ref.Name)
+	}
+
+	resp, err := v.c.GetServerSockets(ctx, &channelzpb.GetServerSocketsRequest{ServerId: s.Ref.ServerId})
+	if err != nil {
+		return err
+	}
+	return v.printSockets(ctx, resp.SocketRef, "  ")
+}
+
+func (v *channelzView) printSockets(ctx context.Context, refs []*channelzpb.SocketRef, indent string) error {
+	for _, ref := range refs {
+		resp, err := v.c.GetSocket(ctx, &channelzpb.GetSocketRequest{SocketId: ref.SocketId})
+		if err != nil {
+			return err
+		}
+
+		s := resp.Socket
+		d := s.Data
+		fmt.Fprintf(v.w, "%ssocket %d local=%s remote=%s streams=%s msgs_sent=%d msgs_recv=%d keepalives=%d\n",
+			indent, s.Ref.SocketId, addr(s.Local), addr(s.Remote),
+			calls(d.StreamsStarted, d.StreamsSucceeded, d.StreamsFailed),
+			d.MessagesSent, d.MessagesReceived, d.KeepAlivesSent)
+	}
+	return nil
+}
+
+// printTransitions prints the most recent connectivity changes in trace.
+func (v *channelzView) printTransitions(trace *channelzpb.ChannelTrace, indent string) {
+	events := make([]*channelzpb.ChannelTraceEvent, 0)
+	for _, e := range trace.GetEvents() {
+		if strings.Contains(e.Description, "Connectivity change") {
+			events = append(events, e)
+		}
+	}
+
+	if len(events) > channelzTransitions {
+		events = events[len(events)-channelzTransitions:]
+	}
+	for _, e := range events {
+		fmt.Fprintf(v.w, "%s%s %s\n", indent, ts(e.Timestamp), e.Description)
+	}
+}
+
+// subchannelAddr digs the backend address out of the subchannel trace, as
+// channelz does not carry it in the subchannel data.
+func subchannelAddr(trace *channelzpb.ChannelTrace) string {
+	a := "-"
+	for _, e := range trace.GetEvents() {
+		i := strings.Index(e.Description, "new address ")
+		if i < 0 {
+			continue
+		}
+		a = strings.Fields(e.Description[i+len("new address "):])[0]
+		a = strings.Trim(a, `"`)
+	}
+	return a
+}
+
+func addr(a *channelzpb.Address) string {
+	switch t := a.GetAddress().(type) {
+	case *channelzpb.Address_TcpipAddress:
+		return net.JoinHostPort(net.IP(t.TcpipAddress.IpAddress).String(),
+			fmt.Sprintf("%d", t.TcpipAddress.Port))
+	case *channelzpb.Address_UdsAddress_:
+		return "unix:" + t.UdsAddress.Filename
+	case *channelzpb.Address_OtherAddress_:
+		return t.OtherAddress.Name
+	default:
+		return "-"
+	}
+}
+
+func calls(started, succeeded, failed int64) string {
+	return fmt.Sprintf("%d/%d/%d", started, succeeded, failed)
+}
+
+func ts(t *timestamp.Timestamp) string {
+	if t == nil {
+		return "-"
+	}
+	tm, err := ptypes.Timestamp(t)
+	if err != nil || tm.Unix() == 0 {
+		return "-"
+	}
+	return tm.Local().Format("15:04:05.000")
+}
+
+func runChannelz(cli *echoClient, argv []string) {
+	usage := `usage: client channelz [--target=<target>] [--watch=<interval>]
+
+options:
+   --target=<target>      Channelz service address, defaults to the first server.
+   --watch=<interval>     Keep printing the view at this interval.
+
+calls & streams are shown as started/succeeded/failed.
+`
+	parser := &docopt.Parser{}
+	args, err := parser.ParseArgs(usage, argv, "1.0")
+	if err != nil {
+		logger.Error("invalid arguments", "err", err)
+		return
+	}
+
+	target := cli.servers[0]
+	if t, err := args.String("--target"); err == nil {
+		target = t
+	}
+
+	var watch time.Duration
+	if _, err := args.String("--watch"); err == nil {
+		if watch, err = parseDuration(args, "--watch"); err != nil {
+			logger.Error("command failed", "err", err)
+			return
+		}
+	}
+
+	conn, err := grpc.Dial(target, grpc.WithInsecure())
+	if err != nil {
+		logger.Error("did not connect", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	v := &channelzView{c: channelzpb.NewChannelzClient(conn), w: os.Stdout}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := v.Print(ctx)
+		cancel()
+		if err != nil {
+			logger.Error("command failed", "err", err)
+			return
+		}
+
+		if watch == 0 {
+			return
+		}
+		time.Sleep(watch)
+		fmt.Println()
+	}
+}
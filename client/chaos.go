@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/chaos"
+	"github.com/1xyz/grpc-playground/endpoint"
+	"github.com/docopt/docopt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+func runChaos(cli *echoClient, argv []string) {
+	usage := `usage: client chaos status [--control=<address>]
+       client chaos set <link> [<fault>...] [--control=<address>]
+       client chaos (heal | drop | reset) <link> [--control=<address>]
+
+options:
+   --control=<address>    Address, or endpoint, of the proxy command's control service [default: :10998].
+
+<link> is a link index or all. set replaces the faults of the link, heal clears
+them, drop closes its open connections & reset resets them.
+
+faults:
+   latency=<duration>      Delay every chunk forwarded, in each direction.
+   jitter=<duration>       Delay each chunk by up to this much more.
+   bandwidth=<bytes>       Limit each direction of a connection to bytes per second.
+   reset-rate=<p>          Reset a new connection w/ probability p.
+   blackhole=<direction>   Swallow the bytes going up (client to server), down or both.
+`
+	parser := &docopt.Parser{}
+	args, err := parser.ParseArgs(usage, argv, "1.0")
+	if err != nil {
+		logger.Error("invalid arguments", "err", err)
+		return
+	}
+
+	addr, _ := args.String("--control")
+	conn, err := grpc.Dial(endpoint.DialTarget(addr), grpc.WithInsecure())
+	if err != nil {
+		logger.Error("did not connect", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	c := api.NewChaosClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := c.ListLinks(ctx, &api.Empty{})
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+
+	if !args["status"].(bool) {
+		var indexes []int32
+		if link, _ := args.String("<link>"); link == "all" {
+			for _, l := range resp.Links {
+				indexes = append(indexes, l.Index)
+			}
+		} else {
+			i, err := strconv.Atoi(link)
+			if err != nil {
+				logger.Error("invalid link", "link", link)
+				return
+			}
+			indexes = append(indexes, int32(i))
+		}
+
+		f, err := chaos.ParseFaults(args["<fault>"].([]string))
+		if err != nil {
+			logger.Error("command failed", "err", err)
+			return
+		}
+
+		for _, i := range indexes {
+			switch {
+			case args["set"].(bool) || args["heal"].(bool):
+				_, err = c.SetFaults(ctx, &api.SetFaultsRequest{Index: i, Faults: f.Proto()})
+			case args["drop"].(bool) || args["reset"].(bool):
+				_, err = c.Drop(ctx, &api.DropRequest{Index: i, WithReset: args["reset"].(bool)})
+			}
+			if err != nil {
+				logger.Error("command failed", "link", i, "err", err)
+				return
+			}
+		}
+
+		if resp, err = c.ListLinks(ctx, &api.Empty{}); err != nil {
+			logger.Error("command failed", "err", err)
+			return
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LINK\tLISTEN\tTARGET\tCONNS\tFAULTS\t")
+	for _, l := range resp.Links {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d/%d\t%v\t\n", l.Index, l.Listen, l.Target,
+			l.ActiveConns, l.AcceptedConns, chaos.FaultsFromProto(l.Faults))
+	}
+	w.Flush()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/endpoint"
+	"github.com/docopt/docopt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// readResolverFile reads server addresses, one per line, skipping blank
+// lines & # comments.
+func readResolverFile(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]string, 0)
+	for _, l := range strings.Split(string(b), "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "#") {
+			servers = append(servers, l)
+		}
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no servers in %v", path)
+	}
+	return servers, nil
+}
+
+func runCluster(cli *echoClient, argv []string) {
+	usage := `usage: client cluster status [--control=<address>]
+       client cluster (kill | restart | pause | resume | promote) <node> [--control=<address>]
+
+options:
+   --control=<address>    Address of the cluster command's control service [default: :10999].
+`
+	parser := &docopt.Parser{}
+	args, err := parser.ParseArgs(usage, argv, "1.0")
+	if err != nil {
+		logger.Error("invalid arguments", "err", err)
+		return
+	}
+
+	addr, _ := args.String("--control")
+	conn, err := grpc.Dial(endpoint.DialTarget(addr), grpc.WithInsecure())
+	if err != nil {
+		logger.Error("did not connect", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	c := api.NewClusterClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !args["status"].(bool) {
+		i, err := args.Int("<node>")
+		if err != nil {
+			logger.Error("command failed", "err", err)
+			return
+		}
+
+		ops := map[string]func(context.Context, *api.NodeRequest, ...grpc.CallOption) (*api.NodeStatus, error){
+			"kill":    c.Kill,
+			"restart": c.Restart,
+			"pause":   c.Pause,
+			"resume":  c.Resume,
+			"promote": c.Promote,
+		}
+		for name, op := range ops {
+			if args[name].(bool) {
+				if _, err := op(ctx, &api.NodeRequest{Index: int32(i)}); err != nil {
+					logger.Error("command failed", "op", name, "node", i, "err", err)
+					return
+				}
+			}
+		}
+	}
+
+	resp, err := c.ListNodes(ctx, &api.Empty{})
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tADDRESS\tSTATE\tLEADER\tSERVER ID\t")
+	for _, n := range resp.Nodes {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%v\t%s\t\n", n.Index, n.Address, n.State, n.IsLeader, n.ServerId)
+	}
+	w.Flush()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/1xyz/grpc-playground/logging"
+	"google.golang.org/grpc/codes"
+	"strconv"
+	"time"
+)
+
+// envPrefix of the environment variables overriding settings, e.g.
+// ECHO_CLIENT_RETRY_MAX_ATTEMPTS=5
+const envPrefix = "ECHO_CLIENT_"
+
+// clientConfig holds the settings of the client shared by its commands, as
+// read from the config file.
+type clientConfig struct {
+	Servers []string `json:"servers"`
+
+	// read the servers from this file instead, when set
+	ResolverFile string `json:"resolver_file"`
+
+	Channelz string `json:"channelz"`
+
+	Balancer string `json:"balancer"`
+
+	BalancerConfig string `json:"balancer_config"`
+
+	// retry policy of the api.Echo methods, for the commands w/ retries
+	RetryMaxAttempts int `json:"retry_max_attempts"`
+
+	RetryInitialBackoff time.Duration `json:"retry_initial_backoff"`
+
+	RetryMaxBackoff time.Duration `json:"retry_max_backoff"`
+
+	RetryBackoffMultiplier float64 `json:"retry_backoff_multiplier"`
+
+	RetryableCodes []string `json:"retryable_codes"`
+
+	// service whose health picks the servers of the health command, the
+	// whole server when empty
+	HealthService string `json:"health_service"`
+
+	// period & timeout of the calls of the health command
+	CallInterval time.Duration `json:"call_interval"`
+
+	CallTimeout time.Duration `json:"call_timeout"`
+
+	LogLevel string `json:"log_level"`
+
+	LogFormat string `json:"log_format"`
+}
+
+func defaultClientConfig() clientConfig {
+	return clientConfig{
+		Servers:                []string{":11000", ":12000", ":13000"},
+		Balancer:               "round_robin",
+		BalancerConfig:         "{}",
+		RetryMaxAttempts:       100,
+		RetryInitialBackoff:    time.Second,
+		RetryMaxBackoff:        100 * time.Second,
+		RetryBackoffMultiplier: 2,
+		RetryableCodes:         []string{"UNAVAILABLE"},
+		CallInterval:           time.Second,
+		CallTimeout:            time.Second,
+		LogLevel:               "info",
+		LogFormat:              logging.FormatLogfmt,
+	}
+}
+
+func (c clientConfig) Validate() error {
+	switch {
+	case len(c.Servers) == 0 && c.ResolverFile == "":
+		return fmt.Errorf("servers or resolver_file must be set")
+	case !json.Valid([]byte(c.BalancerConfig)):
+		return fmt.Errorf("balancer_config = %q is not JSON", c.BalancerConfig)
+	case c.RetryMaxAttempts < 2:
+		return fmt.Errorf("retry_max_attempts = %v must be at least 2", c.RetryMaxAttempts)
+	case c.RetryInitialBackoff <= 0:
+		return fmt.Errorf("retry_initial_backoff must be positive")
+	case c.RetryMaxBackoff < c.RetryInitialBackoff:
+		return fmt.Errorf("retry_max_backoff = %v must not be less than retry_initial_backoff = %v",
+			c.RetryMaxBackoff, c.RetryInitialBackoff)
+	case c.RetryBackoffMultiplier <= 0:
+		return fmt.Errorf("retry_backoff_multiplier = %v must be positive", c.RetryBackoffMultiplier)
+	case len(c.RetryableCodes) == 0:
+		return fmt.Errorf("retryable_codes must not be empty")
+	case c.CallInterval <= 0:
+		return fmt.Errorf("call_interval must be positive")
+	case c.CallTimeout <= 0:
+		return fmt.Errorf("call_timeout must be positive")
+	}
+
+	for _, name := range c.RetryableCodes {
+		var code codes.Code
+		if err := code.UnmarshalJSON([]byte(strconv.Quote(name))); err != nil {
+			return fmt.Errorf("retryable_codes: %q is not a status code like UNAVAILABLE", name)
+		}
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		return fmt.Errorf("log_level: %v", err)
+	}
+	if c.LogFormat != logging.FormatLogfmt && c.LogFormat != logging.FormatJSON {
+		return fmt.Errorf("log_format = %q is not logfmt or json", c.LogFormat)
+	}
+	return nil
+}
+
+// seconds formats d the way durations of service configs are given.
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+// retryPolicy returns the service config retrying the api.Echo methods.
+func (c clientConfig) retryPolicy() string {
+	sc := map[string]interface{}{
+		"methodConfig": []interface{}{map[string]interface{}{
+			"name":         []interface{}{map[string]string{"service": "api.Echo"}},
+			"waitForReady": false,
+			"retryPolicy": map[string]interface{}{
+				"MaxAttempts":          c.RetryMaxAttempts,
+				"InitialBackoff":       seconds(c.RetryInitialBackoff),
+				"MaxBackoff":           seconds(c.RetryMaxBackoff),
+				"BackoffMultiplier":    c.RetryBackoffMultiplier,
+				"RetryableStatusCodes": c.RetryableCodes,
+			},
+		}},
+	}
+	b, _ := json.Marshal(sc)
+	return string(b)
+}
+
+// healthServiceConfig returns the service config of the health command,
+// which balances like the other commands & checks the health of
+// HealthService.
+func (c clientConfig) healthServiceConfig() (string, error) {
+	lb, err := lbServiceConfig(c.Balancer, c.BalancerConfig, true, false)
+	if err != nil {
+		return "", err
+	}
+
+	var sc map[string]interface{}
+	if err := json.Unmarshal([]byte(lb), &sc); err != nil {
+		return "", err
+	}
+	sc["healthCheckConfig"] = map[string]string{"serviceName": c.HealthService}
+	b, err := json.Marshal(sc)
+	return string(b), err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/docopt/docopt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func printHistory(records []*api.EchoRecord) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tCLIENT ID\tPEER\tMETHOD\tCODE\tDURATION\t")
+	for _, r := range records {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%v\t\n", r.Id,
+			time.Unix(0, r.TimeNanos).Format("2006-01-02 15:04:05.000"),
+			r.ClientId, r.Peer, r.Method, r.Code, time.Duration(r.DurationNanos))
+	}
+	w.Flush()
+}
+
+func runHistory(cli *echoClient, argv []string) {
+	usage := `usage: client history [--client-id=<id>] [--since=<duration>] [--page-size=<n>]
+                      [--page-token=<token>] [--all] [--timeout=<timeout>]
+
+options:
+   --client-id=<id>        Only echoes of this client_id.
+   --since=<duration>      Only echoes of the last duration, e.g. 5m.
+   --page-size=<n>         Records per page [default: 20].
+   --page-token=<token>    Continue from the page token of an earlier call.
+   --all                   Follow page tokens to the last page.
+   --timeout=<timeout>     Call timeout [default: 5s].
+
+each server keeps its own history, so every server in --servers is listed.
+`
+	parser := &docopt.Parser{}
+	args, err := parser.ParseArgs(usage, argv, "1.0")
+	if err != nil {
+		logger.Error("invalid arguments", "err", err)
+		return
+	}
+
+	pageSize, err := args.Int("--page-size")
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+	timeout, err := parseDuration(args, "--timeout")
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+
+	req := &api.ListHistoryRequest{PageSize: int32(pageSize)}
+	req.ClientId, _ = args.String("--client-id")
+	if _, err := args.String("--since"); err == nil {
+		since, err := parseDuration(args, "--since")
+		if err != nil {
+			logger.Error("command failed", "err", err)
+			return
+		}
+		req.StartNanos = time.Now().Add(-since).UnixNano()
+	}
+	token, _ := args.String("--page-token")
+	all, _ := args.Bool("--all")
+
+	for _, addr := range cli.servers {
+		conn, err := grpc.Dial(addr, grpc.WithInsecure())
+		if err != nil {
+			logger.Error("did not connect", "err", err)
+			continue
+		}
+
+		c := api.NewEchoClient(conn)
+		fmt.Printf("== %s\n", addr)
+		req.PageToken = token
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			resp, err := c.ListHistory(ctx, req)
+			cancel()
+			if err != nil {
+				logger.Error("call failed", "addr", addr, "err", err)
+				break
+			}
+
+			printHistory(resp.Records)
+			if resp.NextPageToken == "" {
+				break
+			}
+			if !all {
+				fmt.Printf("next page token = %s\n", resp.NextPageToken)
+				break
+			}
+			req.PageToken = resp.NextPageToken
+		}
+		conn.Close()
+	}
+}
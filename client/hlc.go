@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/docopt/docopt-go"
+	"golang.org/x/net/context"
+	"time"
+)
+
+func formatHLC(t *api.HybridTimestamp) string {
+	return fmt.Sprintf("%v+%d", time.Unix(0, t.GetWallNanos()).UTC().Format("15:04:05.000000"), t.GetLogical())
+}
+
+// hlcReport counts the responses whose clocks went backwards relative to the
+// latest one seen before them.
+type hlcReport struct {
+	calls int
+
+	failed int
+
+	// responses from a different server than the one before
+	switches int
+
+	hlcBackwards int
+
+	wallBackwards int
+}
+
+func runHLC(cli *echoClient, argv []string) {
+	usage := `usage: client hlc [--count=<n>] [--interval=<interval>] [--timeout=<timeout>] [--no-merge]
+
+options:
+   --count=<n>              Number of Echo calls [default: 100].
+   --interval=<interval>    Pause between calls [default: 100ms].
+   --timeout=<timeout>      Per call timeout [default: 1s].
+   --no-merge               Do not send the latest hlc seen w/ each call.
+
+calls go over the --balancer policy, so consecutive calls hit different
+servers & fail over when one goes away. Every response hlc must be after
+the latest one seen, a wall clock going backwards is only reported.
+`
+	parser := &docopt.Parser{}
+	args, err := parser.ParseArgs(usage, argv, "1.0")
+	if err != nil {
+		logger.Error("invalid arguments", "err", err)
+		return
+	}
+
+	count, err := args.Int("--count")
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+	interval, err := parseDuration(args, "--interval")
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+	timeout, err := parseDuration(args, "--timeout")
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+	noMerge, _ := args.Bool("--no-merge")
+
+	config, err := lbServiceConfig(cli.balancer, cli.balancerConfig, false, false)
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+
+	conn, cleanup, err := dialServers(cli.servers, config)
+	if err != nil {
+		logger.Error("did not connect", "err", err)
+		return
+	}
+	defer cleanup()
+	defer conn.Close()
+
+	c := api.NewEchoClient(conn)
+	rep := hlcReport{}
+	var last *api.HybridTimestamp
+	var lastWall int64
+	lastServer := ""
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+
+		req := &api.EchoRequest{ClientId: cli.clientId}
+		if !noMerge {
+			req.Hlc = last
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		resp, err := c.Echo(ctx, req)
+		cancel()
+		rep.calls++
+		if err != nil {
+			rep.failed++
+			logger.Warn("echo failed", "err", err)
+			continue
+		}
+
+		if lastServer != "" && resp.ServerId != lastServer {
+			rep.switches++
+		}
+		if last != nil && api.CompareHLC(resp.Hlc, last) <= 0 {
+			rep.hlcBackwards++
+			logger.Warn("hlc went backwards", "from", formatHLC(last), "to", formatHLC(resp.Hlc),
+				"server_id", resp.ServerId)
+		}
+		if lastWall != 0 && resp.ClockNanos < lastWall {
+			rep.wallBackwards++
+			logger.Warn("wall clock went backwards", "by", time.Duration(lastWall-resp.ClockNanos),
+				"server_id", resp.ServerId)
+		}
+
+		if api.CompareHLC(resp.Hlc, last) > 0 {
+			last = resp.Hlc
+		}
+		if resp.ClockNanos > lastWall {
+			lastWall = resp.ClockNanos
+		}
+		lastServer = resp.ServerId
+	}
+
+	fmt.Printf("calls = %d failed = %d server switches = %d\n", rep.calls, rep.failed, rep.switches)
+	fmt.Printf("hlc went backwards %d times, wall clock %d times\n", rep.hlcBackwards, rep.wallBackwards)
+	if last != nil {
+		fmt.Printf("latest hlc = %v\n", formatHLC(last))
+	}
+}
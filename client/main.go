@@ -3,14 +3,19 @@ package main
 import (
 	"fmt"
 	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/config"
+	"github.com/1xyz/grpc-playground/echoerr"
+	"github.com/1xyz/grpc-playground/endpoint"
+	"github.com/1xyz/grpc-playground/logging"
 	"github.com/docopt/docopt-go"
 	"github.com/google/uuid"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	_ "google.golang.org/grpc/health"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/resolver"
 	"google.golang.org/grpc/resolver/manual"
-	"log"
+	"os"
 	"strings"
 	"time"
 )
@@ -20,26 +25,15 @@ import (
 // terrible, this is the worst
 
 var (
-	// see https://github.com/grpc/grpc/blob/master/doc/service_config.md to know more about service config
-	retryPolicy = `{
-		"methodConfig": [{
-		  "name": [{"service": "api.Echo"}],
-		  "waitForReady": false,
-		  "retryPolicy": {
-			  "MaxAttempts": 100,
-			  "InitialBackoff": "1s",
-			  "MaxBackoff": "100s",
-			  "BackoffMultiplier": 2.0,
-			  "RetryableStatusCodes": [ "UNAVAILABLE" ]
-		  }
-		}]}`
-
-	serviceConfig = `{
-		"loadBalancingPolicy": "round_robin",
-		"healthCheckConfig": {
-			"serviceName": ""
-		}
-	}`
+	// the retry policy & the service config of the health command, set up by
+	// main from the config, see
+	// https://github.com/grpc/grpc/blob/master/doc/service_config.md to know more about service config
+	retryPolicy = defaultClientConfig().retryPolicy()
+
+	serviceConfig string
+
+	// w/ the client_id, set up by main
+	logger = logging.Default()
 )
 
 // use grpc.WithDefaultServiceConfig() to set service config
@@ -47,52 +41,144 @@ func retryDial(addr string) (*grpc.ClientConn, error) {
 	return grpc.Dial(addr, grpc.WithInsecure(), grpc.WithDefaultServiceConfig(retryPolicy))
 }
 
+// dialServers connects to all servers through a manual resolver, balancing
+// across them is up to the service config. Call cleanup once conn is closed.
+func dialServers(servers []string, config string, opts ...grpc.DialOption) (conn *grpc.ClientConn, cleanup func(), err error) {
+	r, cleanup := manual.GenerateAndRegisterManualResolver()
+	addresses := make([]resolver.Address, 0)
+	for _, s := range servers {
+		addresses = append(addresses, resolver.Address{Addr: s})
+	}
+	r.InitialState(resolver.State{Addresses: addresses})
+
+	options := []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithDefaultServiceConfig(config),
+		grpc.WithUnaryInterceptor(clientIdInterceptor),
+		grpc.WithStreamInterceptor(clientIdStreamInterceptor),
+	}
+	conn, err = grpc.Dial(fmt.Sprintf("%s:///unused", r.Scheme()), append(options, opts...)...)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return conn, cleanup, nil
+}
+
 type echoClient struct {
 	servers []string
 
 	clientId string
+
+	// load balancing policy & its JSON config
+	balancer string
+
+	balancerConfig string
+
+	// period & timeout of the calls of the health command
+	callInterval time.Duration
+
+	callTimeout time.Duration
 }
 
-func (e *echoClient) OpenSingle() {
+// OpenSingle calls FailingEcho on the first server. Once the retries of the
+// retry policy are used up it goes by the error details & the pushback
+// trailer: it calls again after the delay the server asks for & gives up
+// when the server does not ask for a retry or asks not to retry.
+func (e *echoClient) OpenSingle(argv []string) {
+	usage := `usage: client single [--attempts=<n>] [--max-delay=<duration>]
+
+options:
+   --attempts=<n>            Calls to make, each retried per the retry policy [default: 3].
+   --max-delay=<duration>    Give up when the server asks to wait longer [default: 10s].
+`
+	parser := &docopt.Parser{}
+	args, err := parser.ParseArgs(usage, argv, "1.0")
+	if err != nil {
+		logger.Error("invalid arguments", "err", err)
+		return
+	}
+
+	attempts, err := args.Int("--attempts")
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+
+	maxDelay, err := parseDuration(args, "--max-delay")
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+
 	// Set up a connection to the server.
 	conn, err := retryDial(e.servers[0])
 	if err != nil {
-		log.Fatalf("did not connect: %v", err)
+		logger.Fatalf("did not connect: %v", err)
 	}
 
 	defer conn.Close()
 	c := api.NewEchoClient(conn)
-	resp, err := c.FailingEcho(context.Background(), &api.EchoRequest{ClientId: e.clientId})
-	if err != nil {
-		log.Printf("error = %v", err)
-		return
-	}
+	for i := 1; i <= attempts; i++ {
+		var trailer metadata.MD
+		resp, err := c.FailingEcho(context.Background(), &api.EchoRequest{ClientId: e.clientId}, grpc.Trailer(&trailer))
+		if err == nil {
+			logger.Info("FailingEcho", "resp", resp)
+			return
+		}
+
+		ee, ok := echoerr.FromCall(err, trailer)
+		if !ok {
+			logger.Error("FailingEcho failed", "err", err)
+			return
+		}
+		logger.Warn("FailingEcho failed", "attempt", i, "code", ee.Code, "msg", ee.Message,
+			"reason", ee.Reason, "metadata", ee.Metadata, "debug", ee.Debug)
+
+		delay := ee.RetryDelay
+		switch ee.Reason {
+		case echoerr.ReasonRateLimited:
+			for _, v := range ee.QuotaViolations {
+				logger.Warn("quota exhausted", "subject", v.Subject, "description", v.Description)
+			}
 
-	log.Printf("resp = %v", resp)
+		case echoerr.ReasonInjectedFault:
+			// injected faults hit a fraction of the calls, the next one
+			// may well pass
+			if !ee.Retry && !ee.NoRetry {
+				ee.Retry, delay = true, 0
+			}
+		}
+
+		switch {
+		case i == attempts:
+			logger.Error("FailingEcho gave up", "attempts", attempts)
+			return
+		case ee.NoRetry:
+			logger.Error("FailingEcho gave up, the server asked not to retry", "code", ee.Code)
+			return
+		case !ee.Retry:
+			logger.Error("FailingEcho gave up, the server did not ask for a retry", "code", ee.Code)
+			return
+		case delay > maxDelay:
+			logger.Error("FailingEcho gave up, the server asks to wait too long", "retry_delay", delay)
+			return
+		}
+		logger.Info("calling again", "retry_delay", delay)
+		time.Sleep(delay)
+	}
 }
 
 func (e *echoClient) HealthCheck() {
-	// r, cleanup := manual.GenerateAndRegisterManualResolver()
-	// defer cleanup()
-	//
-	// addresses := make([]resolver.Address, 0)
-	// for _, s := range e.servers {
-	// 	addresses = append(addresses, resolver.Address{Addr: s})
-	// }
-	//
-	// log.Printf("%v\n", addresses)
-	//
-	// r.InitialState(resolver.State{
-	// 	Addresses: addresses,
-	// })
-
 	r, cleanup := manual.GenerateAndRegisterManualResolver()
 	defer cleanup()
+
+	addresses := make([]resolver.Address, 0)
+	for _, s := range e.servers {
+		addresses = append(addresses, resolver.Address{Addr: s})
+	}
 	r.InitialState(resolver.State{
-		Addresses: []resolver.Address{
-			{Addr: ":8080"},
-			{Addr: ":8081"},
-		},
+		Addresses: addresses,
 	})
 
 	address := fmt.Sprintf("%s:///unused", r.Scheme())
@@ -106,62 +192,199 @@ func (e *echoClient) HealthCheck() {
 
 	conn, err := grpc.Dial(address, options...)
 	if err != nil {
-		log.Fatalf("did not connect %v", err)
+		logger.Fatalf("did not connect: %v", err)
 	}
 	defer conn.Close()
 
 	echoClient := api.NewEchoClient(conn)
 	for {
-		callUnaryEcho(echoClient)
-		time.Sleep(time.Second)
+		callUnaryEcho(echoClient, e.callTimeout)
+		time.Sleep(e.callInterval)
 	}
 }
 
-func callUnaryEcho(c api.EchoClient) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+func callUnaryEcho(c api.EchoClient, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	r, err := c.Echo(ctx, &api.EchoRequest{
 		ClientId: uuid.New().String(),
 	})
 
 	if err != nil {
-		log.Printf("UnaryEcho: _, err = %v\n", err)
+		logger.Warn("Echo failed", "err", err)
 	} else {
-		log.Printf("UnaryEcho: clock = %v server_id %v \n", r.Clock, r.ServerId)
+		logger.Info("Echo", "clock", r.Clock, "server_id", r.ServerId)
 	}
 }
 
 func main() {
-	usage := `usage: client [--servers=<servers>]
+	usage := `usage: client [--config=<path>] [--print-config] [--servers=<servers>] [--resolver-file=<path>]
+              [--channelz=<address>] [--balancer=<policy>] [--balancer-config=<json>]
+              [--log-level=<level>] [--log-format=<format>] [<command> [<args>...]]
+
+Settings are the defaults, overridden by the --config file, then by
+ECHO_CLIENT_<SETTING> environment variables, e.g. ECHO_CLIENT_RETRY_MAX_ATTEMPTS=5,
+then by flags. A setting is named like its flag w/ underscores, e.g.
+balancer_config. --print-config lists them all, including the retry policy,
+the health_service & the call_interval & call_timeout of the health command.
+
+grpc only retries w/ GRPC_GO_RETRY=on in the environment. It then waits as
+long as the grpc-retry-pushback-ms trailer of a server asks, or does not
+retry when it asks so, instead of backing off per the retry policy.
 
 options:
-   --servers=<servers>  Server Addresses [default: :11000,:12000,:13000]..
+   --config=<path>            Read settings from this YAML or JSON file.
+   --print-config             Print the settings as YAML & exit, e.g. to start a config file.
+   --servers=<servers>        Server Addresses, :11000,:12000,:13000 by default. Unix sockets
+                              are given as unix:///path, unix:path or unix-abstract:name.
+   --resolver-file=<path>     Read server addresses from this file, one per line, instead.
+   --channelz=<address>       Serve this client's channelz data on address.
+   --balancer=<policy>        Load balancing policy across servers, round_robin by default.
+   --balancer-config=<json>   JSON config of the balancing policy, {} by default.
+   --log-level=<level>        Log debug, info, warn or error & above, info by default.
+   --log-format=<format>      Log records as logfmt or json, logfmt by default.
+
+policies:
+   round_robin        Pick ready servers in turn.
+   circuit_breaker    Round robin that stops picking servers w/ a high error rate,
+                      configured by errorThreshold, window, minRequests,
+                      openTimeout & halfOpenProbes.
+   outlier_detection  Round robin that ejects servers failing calls in a row or
+                      w/ an outlying success rate, for an exponentially growing
+                      time. Configured by interval, baseEjectionTime,
+                      maxEjectionTime, maxEjectionPercent, consecutiveFailures,
+                      successRateStdevFactor, successRateMinimumHosts,
+                      failurePercentageThreshold, failurePercentageMinimumHosts
+                      & requestVolume.
+   weighted_round_robin
+                      Pick servers in proportion to the inverse of the load they
+                      report in call trailers, configured by cpuWeight,
+                      streamWeight, smoothing & weightExpiration.
+   ring_hash          Pick the server owning the hash of the client-id metadata,
+                      which is set from the client_id of the request, on a
+                      consistent hash ring. Configured by ringSize & hashHeader.
+
+commands:
+   health   Call Echo over the health checked --balancer policy (default).
+   single   Call FailingEcho on the first server w/ retries, waiting as the server asks.
+   skew     Estimate clock skew & round trip time of each server.
+   reflect  List, describe & invoke methods via server reflection.
+   channelz Print channels, subchannels & sockets from a channelz service.
+   bench    Generate load & report latency, errors & server distribution.
+   admin    Inspect the runtime state of servers.
+   hashring Show how client_ids spread over servers w/ ring_hash.
+   hlc      Check the hybrid logical clock never goes backwards across servers.
+   history  Page through the echoes recorded by each server.
+   pubsub   Publish to & subscribe to topics across the cluster.
+   stream   Follow StreamEcho, resuming it where it broke off.
+   cluster  Kill, restart, pause or promote nodes of the cluster command.
+   chaos    Inject network faults into the links of the proxy command.
 `
 
 	parser := &docopt.Parser{OptionsFirst: true}
 	args, err := parser.ParseArgs(usage, nil, "1.0")
 	if err != nil {
-		log.Printf("error = %v", err)
+		logger.Error("invalid arguments", "err", err)
 		return
 	}
 
-	log.Printf("%v\n", args)
-	servers, err := args.String("--servers")
-	if err != nil {
-		log.Printf("err = %v\n", err)
+	cfg := defaultClientConfig()
+	path, _ := args.String("--config")
+	if err := config.Load(&cfg, path, envPrefix); err != nil {
+		logger.Error("invalid config", "err", err)
+		os.Exit(2)
+	}
+	if err := config.ApplyFlags(&cfg, args); err != nil {
+		logger.Error("invalid arguments", "err", err)
+		os.Exit(2)
+	}
+	if err := cfg.Validate(); err != nil {
+		logger.Error("invalid config", "err", err)
+		os.Exit(2)
+	}
+	if printConfig, _ := args.Bool("--print-config"); printConfig {
+		if err := config.Write(os.Stdout, &cfg); err != nil {
+			logger.Error("print config", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	clientId := uuid.New().String()
+	if logger, err = logging.Setup(cfg.LogLevel, cfg.LogFormat, "client_id", clientId); err != nil {
+		logging.Default().Error("invalid arguments", "err", err)
+		return
+	}
+
+	logger.Debug("parsed arguments", "args", args, "config", path)
+	retryPolicy = cfg.retryPolicy()
+	if serviceConfig, err = cfg.healthServiceConfig(); err != nil {
+		logger.Error("invalid config", "err", err)
 		return
 	}
 
-	s := strings.Split(servers, ",")
-	for _, e := range s {
-		log.Printf("s = %v\n", e)
+	s := cfg.Servers
+	if cfg.ResolverFile != "" {
+		if s, err = readResolverFile(cfg.ResolverFile); err != nil {
+			logger.Error("command failed", "err", err)
+			return
+		}
+	}
+	for i := range s {
+		s[i] = endpoint.DialTarget(s[i])
+	}
+	logger.Info("servers", "servers", strings.Join(s, ","))
+
+	if cfg.Channelz != "" {
+		cz, err := serveChannelz(cfg.Channelz)
+		if err != nil {
+			logger.Error("command failed", "err", err)
+			return
+		}
+		defer cz.Stop()
 	}
 
 	cli := &echoClient{
-		servers:  s,
-		clientId: uuid.New().String(),
+		servers:        s,
+		clientId:       clientId,
+		balancer:       cfg.Balancer,
+		balancerConfig: cfg.BalancerConfig,
+		callInterval:   cfg.CallInterval,
+		callTimeout:    cfg.CallTimeout,
 	}
 
-	// cli.OpenSingle()
-	cli.HealthCheck()
+	cmd, _ := args["<command>"].(string)
+	argv := append([]string{cmd}, args["<args>"].([]string)...)
+	switch cmd {
+	case "", "health":
+		cli.HealthCheck()
+	case "single":
+		cli.OpenSingle(argv)
+	case "skew":
+		runSkew(cli, argv)
+	case "reflect":
+		runReflect(cli, argv)
+	case "channelz":
+		runChannelz(cli, argv)
+	case "bench":
+		runBench(cli, argv)
+	case "admin":
+		runAdmin(cli, argv)
+	case "hashring":
+		runHashRing(cli, argv)
+	case "hlc":
+		runHLC(cli, argv)
+	case "history":
+		runHistory(cli, argv)
+	case "pubsub":
+		runPubSub(cli, argv)
+	case "stream":
+		runStream(cli, argv)
+	case "cluster":
+		runCluster(cli, argv)
+	case "chaos":
+		runChaos(cli, argv)
+	default:
+		logger.Error("unknown command", "command", cmd)
+	}
 }
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/serviceconfig"
+	"math"
+	"sync"
+	"time"
+)
+
+const outlierPolicy = "outlier_detection"
+
+func init() {
+	balancer.Register(&policyBuilder{
+		name: outlierPolicy,
+		newPickerBuilder: func() configurablePickerBuilder {
+			return &outlierPickerBuilder{d: newOutlierDetector(defaultOutlierConfig())}
+		},
+		parseConfig: parseOutlierConfig,
+	})
+}
+
+// outlierConfig is the loadBalancingConfig of the outlier_detection policy.
+type outlierConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	// how often success rates are evaluated
+	Interval duration `json:"interval"`
+
+	// first ejection time, doubled for every ejection in a row
+	BaseEjectionTime duration `json:"baseEjectionTime"`
+
+	MaxEjectionTime duration `json:"maxEjectionTime"`
+
+	// never eject more than this percent of the backends
+	MaxEjectionPercent int `json:"maxEjectionPercent"`
+
+	// failures in a row that eject a backend right away, 0 disables
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+
+	// backends whose success rate is below mean - stdevFactor * stdev of all
+	// backends are ejected
+	SuccessRateStdevFactor float64 `json:"successRateStdevFactor"`
+
+	SuccessRateMinimumHosts int `json:"successRateMinimumHosts"`
+
+	// backends failing at least this percent of their calls are ejected, 0
+	// disables
+	FailurePercentageThreshold int `json:"failurePercentageThreshold"`
+
+	FailurePercentageMinimumHosts int `json:"failurePercentageMinimumHosts"`
+
+	// calls a backend needs in an interval to have its rates evaluated
+	RequestVolume int `json:"requestVolume"`
+}
+
+func defaultOutlierConfig() *outlierConfig {
+	return &outlierConfig{
+		Interval:                      duration(10 * time.Second),
+		BaseEjectionTime:              duration(30 * time.Second),
+		MaxEjectionTime:               duration(300 * time.Second),
+		MaxEjectionPercent:            50,
+		ConsecutiveFailures:           5,
+		SuccessRateStdevFactor:        1.9,
+		SuccessRateMinimumHosts:       3,
+		FailurePercentageThreshold:    85,
+		FailurePercentageMinimumHosts: 2,
+		RequestVolume:                 10,
+	}
+}
+
+func parseOutlierConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := defaultOutlierConfig()
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case cfg.Interval <= 0 || cfg.BaseEjectionTime <= 0:
+		return nil, fmt.Errorf("interval & baseEjectionTime must be positive")
+	case cfg.MaxEjectionTime < cfg.BaseEjectionTime:
+		return nil, fmt.Errorf("maxEjectionTime must be at least baseEjectionTime")
+	case cfg.MaxEjectionPercent < 0 || cfg.MaxEjectionPercent > 100:
+		return nil, fmt.Errorf("maxEjectionPercent = %v must be in [0, 100]", cfg.MaxEjectionPercent)
+	case cfg.FailurePercentageThreshold < 0 || cfg.FailurePercentageThreshold > 100:
+		return nil, fmt.Errorf("failurePercentageThreshold = %v must be in [0, 100]", cfg.FailurePercentageThreshold)
+	case cfg.ConsecutiveFailures < 0 || cfg.RequestVolume < 1:
+		return nil, fmt.Errorf("consecutiveFailures must not be negative & requestVolume must be positive")
+	}
+	return cfg, nil
+}
+
+type outlierHost struct {
+	addr string
+
+	// outcomes in the current interval
+	successes int
+
+	failures int
+
+	consecutiveFailures int
+
+	ejected bool
+
+	ejectedUntil time.Time
+
+	// ejections in a row, scales the ejection time
+	ejections int
+}
+
+// outlierDetector tracks the outcome of calls per backend & ejects the ones
+// that fail a lot more than the rest, either by failing many calls in a row
+// or by their success rate over an interval. An ejected backend is not
+// picked for baseEjectionTime * 2^(ejections in a row - 1), capped at
+// maxEjectionTime. The rates are evaluated lazily as calls are picked.
+type outlierDetector struct {
+	mu sync.Mutex
+
+	cfg *outlierConfig
+
+	hosts map[string]*outlierHost
+
+	// backends of the current picker
+	active int
+
+	intervalStart time.Time
+}
+
+func newOutlierDetector(cfg *outlierConfig) *outlierDetector {
+	return &outlierDetector{
+		cfg:           cfg,
+		hosts:         make(map[string]*outlierHost),
+		intervalStart: time.Now(),
+	}
+}
+
+func (d *outlierDetector) host(addr string) *outlierHost {
+	h, ok := d.hosts[addr]
+	if !ok {
+		h = &outlierHost{addr: addr}
+		d.hosts[addr] = h
+	}
+	return h
+}
+
+// isEjected tells if h is ejected at now, returning it once its time is up.
+func (d *outlierDetector) isEjected(h *outlierHost, now time.Time) bool {
+	if h.ejected && !now.Before(h.ejectedUntil) {
+		h.ejected = false
+		h.consecutiveFailures = 0
+		logger.Info("host returned", "component", "outlier", "addr", h.addr, "ejection", h.ejections)
+	}
+	return h.ejected
+}
+
+func (d *outlierDetector) ejectedCount(now time.Time) int {
+	n := 0
+	for _, h := range d.hosts {
+		if d.isEjected(h, now) {
+			n++
+		}
+	}
+	return n
+}
+
+func (d *outlierDetector) eject(h *outlierHost, now time.Time, reason string) {
+	if d.isEjected(h, now) {
+		return
+	}
+	if 100*(d.ejectedCount(now)+1) > d.cfg.MaxEjectionPercent*d.active {
+		logger.Info("host not ejected, maxEjectionPercent reached", "component", "outlier", "addr", h.addr, "reason", reason)
+		return
+	}
+
+	h.ejections++
+	t := time.Duration(d.cfg.BaseEjectionTime) * time.Duration(1<<uint(minInt(h.ejections-1, 30)))
+	if max := time.Duration(d.cfg.MaxEjectionTime); t > max || t <= 0 {
+		t = max
+	}
+	h.ejected = true
+	h.ejectedUntil = now.Add(t)
+	logger.Info("host ejected", "component", "outlier", "addr", h.addr, "for", t, "reason", reason, "ejection", h.ejections)
+}
+
+func (d *outlierDetector) record(addr string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	h := d.host(addr)
+	if !isBackendFailure(err) {
+		h.successes++
+		h.consecutiveFailures = 0
+		return
+	}
+
+	h.failures++
+	h.consecutiveFailures++
+	if d.cfg.ConsecutiveFailures > 0 && h.consecutiveFailures >= d.cfg.ConsecutiveFailures {
+		d.eject(h, time.Now(), fmt.Sprintf("%d consecutive failures", h.consecutiveFailures))
+	}
+}
+
+// maybeEvaluate runs the success rate & failure percentage checks once an
+// interval has passed.
+func (d *outlierDetector) maybeEvaluate(now time.Time) {
+	if now.Sub(d.intervalStart) < time.Duration(d.cfg.Interval) {
+		return
+	}
+	d.intervalStart = now
+
+	candidates := make([]*outlierHost, 0)
+	for _, h := range d.hosts {
+		if !d.isEjected(h, now) && h.successes+h.failures >= d.cfg.RequestVolume {
+			candidates = append(candidates, h)
+		}
+	}
+
+	rate := func(h *outlierHost) float64 {
+		return 100 * float64(h.successes) / float64(h.successes+h.failures)
+	}
+
+	if len(candidates) >= d.cfg.SuccessRateMinimumHosts && len(candidates) > 0 {
+		mean := 0.0
+		for _, h := range candidates {
+			mean += rate(h)
+		}
+		mean /= float64(len(candidates))
+
+		variance := 0.0
+		for _, h := range candidates {
+			variance += (rate(h) - mean) * (rate(h) - mean)
+		}
+		stdev := math.Sqrt(variance / float64(len(candidates)))
+
+		threshold := mean - d.cfg.SuccessRateStdevFactor*stdev
+		for _, h := range candidates {
+			if rate(h) < threshold {
+				d.eject(h, now, fmt.Sprintf("success rate %.1f%% < %.1f%%", rate(h), threshold))
+			}
+		}
+	}
+
+	if d.cfg.FailurePercentageThreshold > 0 && len(candidates) >= d.cfg.FailurePercentageMinimumHosts {
+		for _, h := range candidates {
+			if 100-rate(h) >= float64(d.cfg.FailurePercentageThreshold) {
+				d.eject(h, now, fmt.Sprintf("failure percentage %.1f%%", 100-rate(h)))
+			}
+		}
+	}
+
+	for _, h := range d.hosts {
+		// a backend that made it through an interval earns back an ejection
+		if !d.isEjected(h, now) && h.ejections > 0 {
+			h.ejections--
+		}
+		h.successes = 0
+		h.failures = 0
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+type outlierPickerBuilder struct {
+	d *outlierDetector
+}
+
+func (b *outlierPickerBuilder) UpdateConfig(cfg serviceconfig.LoadBalancingConfig) {
+	if c, ok := cfg.(*outlierConfig); ok {
+		b.d.mu.Lock()
+		b.d.cfg = c
+		b.d.mu.Unlock()
+	}
+}
+
+func (b *outlierPickerBuilder) Build(info base.PickerBuildInfo) balancer.V2Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPickerV2(balancer.ErrNoSubConnAvailable)
+	}
+
+	b.d.mu.Lock()
+	defer b.d.mu.Unlock()
+
+	b.d.active = len(info.ReadySCs)
+	p := &outlierPicker{d: b.d}
+	for sc, sci := range info.ReadySCs {
+		p.subConns = append(p.subConns, sc)
+		p.hosts = append(p.hosts, b.d.host(sci.Address.Addr))
+	}
+	return p
+}
+
+// outlierPicker round robins over the ready subconns that are not ejected.
+// If every one of them is ejected it falls back to picking from all.
+type outlierPicker struct {
+	d *outlierDetector
+
+	subConns []balancer.SubConn
+
+	hosts []*outlierHost
+
+	next int
+}
+
+func (p *outlierPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	p.d.mu.Lock()
+	defer p.d.mu.Unlock()
+
+	now := time.Now()
+	p.d.maybeEvaluate(now)
+
+	n := len(p.subConns)
+	idx := p.next % n
+	for i := 0; i < n; i++ {
+		j := (p.next + i) % n
+		if !p.d.isEjected(p.hosts[j], now) {
+			idx = j
+			break
+		}
+	}
+	p.next = idx + 1
+
+	addr := p.hosts[idx].addr
+	return balancer.PickResult{
+		SubConn: p.subConns[idx],
+		Done: func(di balancer.DoneInfo) {
+			p.d.record(addr, di.Err)
+		},
+	}, nil
+}
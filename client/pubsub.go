@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/docopt/docopt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+func runPubSub(cli *echoClient, argv []string) {
+	usage := `usage: client pubsub publish <topic> <payload> [--count=<n>] [--interval=<interval>]
+       client pubsub subscribe <topic> [--from=<sequence>]
+
+options:
+   --count=<n>              Publish the payload this many times, suffixed w/ #i [default: 1].
+   --interval=<interval>    Pause between publishes [default: 1s].
+   --from=<sequence>        Replay retained messages from this sequence on, 0 for only new
+                            ones [default: 0].
+
+calls go over the --balancer policy, so any server may take them. A broken
+subscription is resumed from the sequence after the last one received.
+`
+	parser := &docopt.Parser{}
+	args, err := parser.ParseArgs(usage, argv, "1.0")
+	if err != nil {
+		logger.Error("invalid arguments", "err", err)
+		return
+	}
+
+	config, err := lbServiceConfig(cli.balancer, cli.balancerConfig, false, false)
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+
+	conn, cleanup, err := dialServers(cli.servers, config)
+	if err != nil {
+		logger.Error("did not connect", "err", err)
+		return
+	}
+	defer cleanup()
+	defer conn.Close()
+
+	c := api.NewPubSubClient(conn)
+	topic, _ := args.String("<topic>")
+	if args["publish"].(bool) {
+		payload, _ := args.String("<payload>")
+		count, err := args.Int("--count")
+		if err != nil {
+			logger.Error("command failed", "err", err)
+			return
+		}
+		interval, err := parseDuration(args, "--interval")
+		if err != nil {
+			logger.Error("command failed", "err", err)
+			return
+		}
+
+		for i := 0; i < count; i++ {
+			if i > 0 {
+				time.Sleep(interval)
+			}
+
+			p := payload
+			if count > 1 {
+				p = fmt.Sprintf("%s #%d", payload, i)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			resp, err := c.Publish(ctx, &api.PublishRequest{Topic: topic, Payload: []byte(p), ClientId: cli.clientId})
+			cancel()
+			if err != nil {
+				logger.Error("publish failed", "topic", topic, "err", err)
+				continue
+			}
+			fmt.Printf("published %q to %s seq = %d\n", p, topic, resp.Sequence)
+		}
+		return
+	}
+
+	from, err := args.Int("--from")
+	if err != nil || from < 0 {
+		logger.Error("invalid --from")
+		return
+	}
+	subscribe(c, topic, uint64(from), cli.clientId)
+}
+
+// subscribe prints the messages of topic, resubscribing w/ the sequence
+// after the last one received whenever the stream breaks.
+func subscribe(c api.PubSubClient, topic string, from uint64, clientId string) {
+	for {
+		stream, err := c.Subscribe(context.Background(), &api.SubscribeRequest{
+			Topic:        topic,
+			FromSequence: from,
+			ClientId:     clientId,
+		})
+		for err == nil {
+			var m *api.Message
+			if m, err = stream.Recv(); err != nil {
+				break
+			}
+
+			if from > 0 && m.Sequence != from {
+				logger.Warn("subscription gap", "topic", topic, "expected", from, "got", m.Sequence)
+			}
+			fmt.Printf("%s seq = %d %v %q\n", m.Topic, m.Sequence,
+				time.Unix(0, m.PublishedNanos).Format("15:04:05.000"), m.Payload)
+			from = m.Sequence + 1
+		}
+
+		if status.Code(err) == codes.OutOfRange || status.Code(err) == codes.InvalidArgument {
+			logger.Error("subscription failed", "topic", topic, "err", err)
+			return
+		}
+		logger.Warn("subscription broke, resuming", "topic", topic, "from", from, "err", err)
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/docopt/docopt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reflectClient resolves services & messages from a server's reflection
+// service, so methods can be invoked w/o the generated api package.
+type reflectClient struct {
+	stream rpb.ServerReflection_ServerReflectionInfoClient
+
+	// file descriptors fetched so far, keyed by file name
+	files map[string]*descriptorpb.FileDescriptorProto
+}
+
+func newReflectClient(ctx context.Context, conn *grpc.ClientConn) (*reflectClient, error) {
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reflectClient{
+		stream: stream,
+		files:  make(map[string]*descriptorpb.FileDescriptorProto),
+	}, nil
+}
+
+func (r *reflectClient) send(req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+	if err := r.stream.Send(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := r.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	if e := resp.GetErrorResponse(); e != nil {
+		return nil, fmt.Errorf("reflection error code = %v: %v", e.ErrorCode, e.ErrorMessage)
+	}
+	return resp, nil
+}
+
+func (r *reflectClient) ListServices() ([]string, error) {
+	resp, err := r.send(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for _, s := range resp.GetListServicesResponse().Service {
+		names = append(names, s.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// loadSymbol fetches the file defining symbol along w/ its dependencies.
+func (r *reflectClient) loadSymbol(symbol string) error {
+	resp, err := r.send(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+	})
+	if err != nil {
+		return err
+	}
+	return r.addFiles(resp)
+}
+
+func (r *reflectClient) loadFile(name string) error {
+	resp, err := r.send(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: name},
+	})
+	if err != nil {
+		return err
+	}
+	return r.addFiles(resp)
+}
+
+func (r *reflectClient) addFiles(resp *rpb.ServerReflectionResponse) error {
+	for _, b := range resp.GetFileDescriptorResponse().FileDescriptorProto {
+		fd := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(b, fd); err != nil {
+			return err
+		}
+		r.files[fd.GetName()] = fd
+	}
+
+	// the server is not required to send every transitive dependency
+	for _, fd := range r.files {
+		for _, dep := range fd.Dependency {
+			if _, ok := r.files[dep]; ok {
+				continue
+			}
+			if err := r.loadFile(dep); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Resolve returns the descriptor for a fully qualified symbol, which can be
+// a service, method (svc.Method or svc/Method), message or enum.
+func (r *reflectClient) Resolve(symbol string) (protoreflect.Descriptor, error) {
+	symbol = strings.TrimPrefix(symbol, ".")
+	lookup := symbol
+	if i := strings.LastIndex(symbol, "/"); i >= 0 {
+		symbol = symbol[:i] + "." + symbol[i+1:]
+		lookup = symbol[:i]
+	}
+
+	if err := r.loadSymbol(lookup); err != nil {
+		// methods are not symbols of their own for every server
+		i := strings.LastIndex(lookup, ".")
+		if i < 0 || r.loadSymbol(lookup[:i]) != nil {
+			return nil, err
+		}
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range r.files {
+		set.File = append(set.File, fd)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+	return files.FindDescriptorByName(protoreflect.FullName(symbol))
+}
+
+// Invoke calls the method w/ the JSON encoded request(s) in data and hands
+// every JSON encoded response to emit as it arrives. A client streaming
+// method takes one request for every JSON value found in data.
+func (r *reflectClient) Invoke(ctx context.Context, conn *grpc.ClientConn,
+	md protoreflect.MethodDescriptor, data string, emit func(string)) error {
+	reqs := make([]proto.Message, 0)
+	dec := json.NewDecoder(strings.NewReader(data))
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		req := dynamicpb.NewMessage(md.Input())
+		if err := protojson.Unmarshal(raw, req); err != nil {
+			return err
+		}
+		reqs = append(reqs, req)
+	}
+
+	if len(reqs) == 0 {
+		reqs = append(reqs, dynamicpb.NewMessage(md.Input()))
+	}
+	if len(reqs) > 1 && !md.IsStreamingClient() {
+		return fmt.Errorf("%v takes a single request, got %d", md.FullName(), len(reqs))
+	}
+
+	method := fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+	desc := &grpc.StreamDesc{
+		StreamName:    string(md.Name()),
+		ServerStreams: md.IsStreamingServer(),
+		ClientStreams: md.IsStreamingClient(),
+	}
+
+	stream, err := conn.NewStream(ctx, desc, method)
+	if err != nil {
+		return err
+	}
+
+	for _, req := range reqs {
+		if err := stream.SendMsg(req); err != nil {
+			return err
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	opts := protojson.MarshalOptions{Multiline: true, Indent: "  "}
+	for {
+		resp := dynamicpb.NewMessage(md.Output())
+		if err := stream.RecvMsg(resp); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		b, err := opts.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		emit(string(b))
+
+		if !md.IsStreamingServer() {
+			return nil
+		}
+	}
+	return nil
+}
+
+// describe renders a descriptor in a proto like syntax.
+func describe(d protoreflect.Descriptor) string {
+	var b bytes.Buffer
+	switch t := d.(type) {
+	case protoreflect.ServiceDescriptor:
+		fmt.Fprintf(&b, "service %s {\n", t.Name())
+		for i := 0; i < t.Methods().Len(); i++ {
+			fmt.Fprintf(&b, "    %s\n", methodSignature(t.Methods().Get(i)))
+		}
+		fmt.Fprintf(&b, "}\n")
+
+	case protoreflect.MethodDescriptor:
+		fmt.Fprintf(&b, "%s\n", methodSignature(t))
+
+	case protoreflect.MessageDescriptor:
+		fmt.Fprintf(&b, "message %s {\n", t.Name())
+		for i := 0; i < t.Fields().Len(); i++ {
+			f := t.Fields().Get(i)
+			label := ""
+			if f.Cardinality() == protoreflect.Repeated && !f.IsMap() {
+				label = "repeated "
+			}
+			fmt.Fprintf(&b, "    %s%s %s = %d;\n", label, fieldType(f), f.Name(), f.Number())
+		}
+		fmt.Fprintf(&b, "}\n")
+
+	case protoreflect.EnumDescriptor:
+		fmt.Fprintf(&b, "enum %s {\n", t.Name())
+		for i := 0; i < t.Values().Len(); i++ {
+			v := t.Values().Get(i)
+			fmt.Fprintf(&b, "    %s = %d;\n", v.Name(), v.Number())
+		}
+		fmt.Fprintf(&b, "}\n")
+
+	default:
+		fmt.Fprintf(&b, "%s\n", d.FullName())
+	}
+	return b.String()
+}
+
+func methodSignature(m protoreflect.MethodDescriptor) string {
+	in, out := "", ""
+	if m.IsStreamingClient() {
+		in = "stream "
+	}
+	if m.IsStreamingServer() {
+		out = "stream "
+	}
+	return fmt.Sprintf("rpc %s(%s%s) returns (%s%s) {}",
+		m.Name(), in, m.Input().FullName(), out, m.Output().FullName())
+}
+
+func fieldType(f protoreflect.FieldDescriptor) string {
+	switch {
+	case f.IsMap():
+		return fmt.Sprintf("map<%s, %s>", fieldType(f.MapKey()), fieldType(f.MapValue()))
+	case f.Message() != nil:
+		return string(f.Message().FullName())
+	case f.Enum() != nil:
+		return string(f.Enum().FullName())
+	default:
+		return f.Kind().String()
+	}
+}
+
+func runReflect(cli *echoClient, argv []string) {
+	usage := `usage: client reflect list
+       client reflect describe <symbol>
+       client reflect invoke <method> [--data=<json>] [--header=<kv>...] [--timeout=<timeout>]
+
+options:
+   --data=<json>          JSON request body, one value per request [default: {}].
+   --header=<kv>          Request metadata as key=value.
+   --timeout=<timeout>    Call timeout [default: 10s].
+`
+	parser := &docopt.Parser{}
+	args, err := parser.ParseArgs(usage, argv, "1.0")
+	if err != nil {
+		logger.Error("invalid arguments", "err", err)
+		return
+	}
+
+	timeout, err := parseDuration(args, "--timeout")
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+
+	conn, err := grpc.Dial(cli.servers[0], grpc.WithInsecure())
+	if err != nil {
+		logger.Error("did not connect", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	rc, err := newReflectClient(ctx, conn)
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+
+	switch {
+	case args["list"].(bool):
+		names, err := rc.ListServices()
+		if err != nil {
+			logger.Error("command failed", "err", err)
+			return
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+
+	case args["describe"].(bool):
+		symbol, _ := args.String("<symbol>")
+		d, err := rc.Resolve(symbol)
+		if err != nil {
+			logger.Error("command failed", "err", err)
+			return
+		}
+		fmt.Print(describe(d))
+
+	case args["invoke"].(bool):
+		method, _ := args.String("<method>")
+		d, err := rc.Resolve(method)
+		if err != nil {
+			logger.Error("command failed", "err", err)
+			return
+		}
+
+		md, ok := d.(protoreflect.MethodDescriptor)
+		if !ok {
+			logger.Error("not a method", "method", method)
+			return
+		}
+
+		for _, h := range args["--header"].([]string) {
+			kv := strings.SplitN(h, "=", 2)
+			if len(kv) != 2 {
+				logger.Error("invalid header", "header", h)
+				return
+			}
+			ctx = metadata.AppendToOutgoingContext(ctx, kv[0], kv[1])
+		}
+
+		data, _ := args.String("--data")
+		start := time.Now()
+		err = rc.Invoke(ctx, conn, md, data, func(s string) {
+			fmt.Println(s)
+		})
+		if err != nil {
+			logger.Error("command failed", "err", err)
+			return
+		}
+		logger.Info("invoked", "method", md.FullName(), "duration", time.Since(start))
+	}
+}
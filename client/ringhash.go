@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/docopt/docopt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/serviceconfig"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+const ringHashPolicy = "ring_hash"
+
+// clientIdHeader is the request metadata that carries the client_id of a
+// request, ring_hash hashes it by default.
+const clientIdHeader = "client-id"
+
+func init() {
+	balancer.Register(&policyBuilder{
+		name: ringHashPolicy,
+		newPickerBuilder: func() configurablePickerBuilder {
+			return &ringHashPickerBuilder{cfg: defaultRingHashConfig()}
+		},
+		parseConfig: parseRingHashConfig,
+	})
+}
+
+// ringHashConfig is the loadBalancingConfig of the ring_hash policy.
+type ringHashConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	// points each server has on the ring, more points spread keys more
+	// evenly at the cost of a bigger ring
+	RingSize int `json:"ringSize"`
+
+	// request metadata holding the hash key
+	HashHeader string `json:"hashHeader"`
+}
+
+func defaultRingHashConfig() *ringHashConfig {
+	return &ringHashConfig{RingSize: 1024, HashHeader: clientIdHeader}
+}
+
+func parseRingHashConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := defaultRingHashConfig()
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case cfg.RingSize < 1 || cfg.RingSize > 1<<16:
+		return nil, fmt.Errorf("ringSize = %v must be in [1, 65536]", cfg.RingSize)
+	case cfg.HashHeader == "":
+		return nil, fmt.Errorf("hashHeader must be set")
+	}
+	return cfg, nil
+}
+
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+
+	// fnv alone clusters keys that differ only in their last bytes, the
+	// splitmix64 finalizer spreads them over the ring
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+type ringEntry struct {
+	hash uint64
+
+	// index of the server in hashRing.addrs
+	server int
+}
+
+// hashRing places ringSize points per server address on a ring of hashes.
+// A key belongs to the server of the first point at or after its hash. The
+// points of a server depend only on its address, so when a server joins or
+// leaves only the keys landing on its points move.
+type hashRing struct {
+	addrs []string
+
+	entries []ringEntry
+}
+
+func newHashRing(addrs []string, ringSize int) *hashRing {
+	r := &hashRing{addrs: addrs, entries: make([]ringEntry, 0, len(addrs)*ringSize)}
+	for i, addr := range addrs {
+		for j := 0; j < ringSize; j++ {
+			r.entries = append(r.entries, ringEntry{hash: hashKey(addr + "_" + strconv.Itoa(j)), server: i})
+		}
+	}
+	sort.Slice(r.entries, func(i, j int) bool { return r.entries[i].hash < r.entries[j].hash })
+	return r
+}
+
+// Lookup returns the index of the server owning hash h, false when the ring
+// is empty.
+func (r *hashRing) Lookup(h uint64) (int, bool) {
+	if len(r.entries) == 0 {
+		return 0, false
+	}
+	i := sort.Search(len(r.entries), func(i int) bool { return r.entries[i].hash >= h })
+	if i == len(r.entries) {
+		i = 0
+	}
+	return r.entries[i].server, true
+}
+
+type ringHashPickerBuilder struct {
+	cfg *ringHashConfig
+}
+
+func (b *ringHashPickerBuilder) UpdateConfig(cfg serviceconfig.LoadBalancingConfig) {
+	if c, ok := cfg.(*ringHashConfig); ok {
+		b.cfg = c
+	}
+}
+
+func (b *ringHashPickerBuilder) Build(info base.PickerBuildInfo) balancer.V2Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPickerV2(balancer.ErrNoSubConnAvailable)
+	}
+
+	subConns := make(map[string]balancer.SubConn)
+	addrs := make([]string, 0, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		subConns[sci.Address.Addr] = sc
+		addrs = append(addrs, sci.Address.Addr)
+	}
+	sort.Strings(addrs)
+
+	p := &ringHashPicker{header: b.cfg.HashHeader, ring: newHashRing(addrs, b.cfg.RingSize)}
+	for _, addr := range addrs {
+		p.subConns = append(p.subConns, subConns[addr])
+	}
+	return p
+}
+
+// ringHashPicker picks the ready subconn owning the hash of the header of
+// a call on the ring. A call w/o the header goes to a random subconn.
+type ringHashPicker struct {
+	header string
+
+	ring *hashRing
+
+	subConns []balancer.SubConn
+}
+
+func (p *ringHashPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	h := rand.Uint64()
+	if md, ok := metadata.FromOutgoingContext(info.Ctx); ok {
+		if v := md.Get(p.header); len(v) > 0 {
+			h = hashKey(v[0])
+		}
+	}
+	i, ok := p.ring.Lookup(h)
+	if !ok {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	return balancer.PickResult{SubConn: p.subConns[i]}, nil
+}
+
+// clientIdInterceptor sends the client_id of a request as its client-id
+// metadata, so that balancers can pick on it.
+func clientIdInterceptor(ctx context.Context, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(withClientId(ctx, req), method, req, reply, cc, opts...)
+}
+
+// withClientId adds the client_id of req, if any, to the metadata of ctx.
+func withClientId(ctx context.Context, req interface{}) context.Context {
+	if r, ok := req.(interface{ GetClientId() string }); ok && r.GetClientId() != "" {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		if len(md.Get(clientIdHeader)) == 0 {
+			ctx = metadata.AppendToOutgoingContext(ctx, clientIdHeader, r.GetClientId())
+		}
+	}
+	return ctx
+}
+
+// clientIdStreamInterceptor is clientIdInterceptor for server streams, e.g.
+// StreamEcho & Subscribe. Their request is only known once it is sent, so
+// the stream, & w/ it the pick of the balancer, waits for it.
+func clientIdStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+	method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if desc.ClientStreams {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	return &clientIdStream{ctx: ctx, start: func(ctx context.Context) (grpc.ClientStream, error) {
+		return streamer(ctx, desc, cc, method, opts...)
+	}}, nil
+}
+
+// clientIdStream starts the stream w/ the client_id of the first message
+// sent, or w/o one when it is used otherwise first.
+type clientIdStream struct {
+	grpc.ClientStream
+
+	ctx context.Context
+
+	start func(ctx context.Context) (grpc.ClientStream, error)
+}
+
+func (s *clientIdStream) started(req interface{}) error {
+	if s.ClientStream != nil {
+		return nil
+	}
+	cs, err := s.start(withClientId(s.ctx, req))
+	if err != nil {
+		return err
+	}
+	s.ClientStream = cs
+	return nil
+}
+
+func (s *clientIdStream) SendMsg(m interface{}) error {
+	if err := s.started(m); err != nil {
+		return err
+	}
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *clientIdStream) RecvMsg(m interface{}) error {
+	if err := s.started(nil); err != nil {
+		return err
+	}
+	return s.ClientStream.RecvMsg(m)
+}
+
+func (s *clientIdStream) Header() (metadata.MD, error) {
+	if err := s.started(nil); err != nil {
+		return nil, err
+	}
+	return s.ClientStream.Header()
+}
+
+func (s *clientIdStream) Trailer() metadata.MD {
+	if s.ClientStream == nil {
+		return nil
+	}
+	return s.ClientStream.Trailer()
+}
+
+func (s *clientIdStream) CloseSend() error {
+	if err := s.started(nil); err != nil {
+		return err
+	}
+	return s.ClientStream.CloseSend()
+}
+
+func (s *clientIdStream) Context() context.Context {
+	if s.ClientStream == nil {
+		return s.ctx
+	}
+	return s.ClientStream.Context()
+}
+
+func runHashRing(cli *echoClient, argv []string) {
+	usage := `usage: client hashring [--keys=<n>] [--ring-size=<n>] [--remove=<address>] [--live]
+
+options:
+   --keys=<n>             Number of client_ids to place [default: 10000].
+   --ring-size=<n>        Points per server on the ring [default: 1024].
+   --remove=<address>     Also show which keys move when this server leaves.
+   --live                 Call Echo for each key over ring_hash & report the
+                          servers that answered.
+
+the client_ids are key-0, key-1, ... & the ring is built from --servers.
+`
+	parser := &docopt.Parser{}
+	args, err := parser.ParseArgs(usage, argv, "1.0")
+	if err != nil {
+		logger.Error("invalid arguments", "err", err)
+		return
+	}
+
+	keys, err := args.Int("--keys")
+	if err != nil || keys < 1 {
+		logger.Error("invalid arguments", "err", fmt.Errorf("--keys = %v must be a number above 0", args["--keys"]))
+		return
+	}
+	ringSize, err := args.Int("--ring-size")
+	if err != nil || ringSize < 1 || ringSize > 1<<16 {
+		logger.Error("invalid arguments", "err", fmt.Errorf("--ring-size = %v must be a number in [1, 65536]", args["--ring-size"]))
+		return
+	}
+
+	addrs := append([]string{}, cli.servers...)
+	sort.Strings(addrs)
+	ring := newHashRing(addrs, ringSize)
+	owners := make([]string, keys)
+	counts := make(map[string]int)
+	for i := range owners {
+		j, _ := ring.Lookup(hashKey("key-" + strconv.Itoa(i)))
+		owners[i] = addrs[j]
+		counts[owners[i]]++
+	}
+
+	fmt.Printf("%d keys on %d servers w/ %d points each\n", keys, len(addrs), ringSize)
+	printDistribution(addrs, counts, keys)
+
+	if removed, err := args.String("--remove"); err == nil {
+		rest := make([]string, 0)
+		for _, a := range addrs {
+			if a != removed {
+				rest = append(rest, a)
+			}
+		}
+		if len(rest) == len(addrs) || len(rest) == 0 {
+			logger.Error("--remove is not one of several --servers", "addr", removed)
+			return
+		}
+
+		smaller := newHashRing(rest, ringSize)
+		moved, movedOther := 0, 0
+		counts = make(map[string]int)
+		for i, owner := range owners {
+			j, _ := smaller.Lookup(hashKey("key-" + strconv.Itoa(i)))
+			a := rest[j]
+			counts[a]++
+			if a != owner {
+				moved++
+				if owner != removed {
+					movedOther++
+				}
+			}
+		}
+
+		fmt.Printf("\nw/o %s, %d keys moved (%.2f%%), %d of them not owned by %s\n",
+			removed, moved, 100*float64(moved)/float64(keys), movedOther, removed)
+		printDistribution(rest, counts, keys)
+	}
+
+	if live, _ := args.Bool("--live"); live {
+		runLiveHashRing(cli, keys, ringSize)
+	}
+}
+
+func printDistribution(addrs []string, counts map[string]int, total int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "SERVER\tKEYS\tSHARE\t")
+	for _, a := range addrs {
+		fmt.Fprintf(w, "%s\t%d\t%.2f%%\t\n", a, counts[a], 100*float64(counts[a])/float64(total))
+	}
+	w.Flush()
+}
+
+// runLiveHashRing calls Echo twice for every key over ring_hash, reporting
+// how keys spread over the servers that answered & any key that was not
+// answered by the same server both times.
+func runLiveHashRing(cli *echoClient, keys int, ringSize int) {
+	config, err := lbServiceConfig(ringHashPolicy, fmt.Sprintf(`{"ringSize": %d}`, ringSize), false, false)
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+
+	conn, cleanup, err := dialServers(cli.servers, config, grpc.WithBlock())
+	if err != nil {
+		logger.Error("did not connect", "err", err)
+		return
+	}
+	defer cleanup()
+	defer conn.Close()
+
+	c := api.NewEchoClient(conn)
+	counts := make(map[string]int)
+	moved, failed := 0, 0
+	for i := 0; i < keys; i++ {
+		key := "key-" + strconv.Itoa(i)
+		servers := make([]string, 0, 2)
+		for j := 0; j < 2; j++ {
+			resp, err := c.Echo(context.Background(), &api.EchoRequest{ClientId: key})
+			if err != nil {
+				failed++
+				continue
+			}
+			servers = append(servers, resp.ServerId)
+		}
+
+		if len(servers) == 2 && servers[0] != servers[1] {
+			moved++
+		}
+		if len(servers) > 0 {
+			counts[servers[0]]++
+		}
+	}
+
+	ids := make([]string, 0)
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	fmt.Printf("\nlive: %d keys, %d failed calls, %d keys answered by different servers\n", keys, failed, moved)
+	printDistribution(ids, counts, keys)
+}
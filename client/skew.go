@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/docopt/docopt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// skewSample is the result of one Cristian style exchange w/ a server.
+//
+// The client records t0 before the request & t1 after the response, the
+// server stamps its clock ts in between. Assuming a symmetric path the
+// server read its clock at (t0 + t1) / 2, so offset = ts - (t0 + t1) / 2,
+// and the estimate is off by at most rtt / 2.
+type skewSample struct {
+	rtt    time.Duration
+	offset time.Duration
+}
+
+// skewReport collects all the samples taken against one server address.
+type skewReport struct {
+	addr string
+
+	serverId string
+
+	samples []skewSample
+
+	errors []error
+}
+
+// best returns the sample w/ the smallest round trip, which carries the
+// tightest error bound on the offset.
+func (r *skewReport) best() skewSample {
+	b := r.samples[0]
+	for _, s := range r.samples[1:] {
+		if s.rtt < b.rtt {
+			b = s
+		}
+	}
+	return b
+}
+
+func (r *skewReport) rtts() []time.Duration {
+	d := make([]time.Duration, len(r.samples))
+	for i, s := range r.samples {
+		d[i] = s.rtt
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	return d
+}
+
+func (r *skewReport) medianOffset() time.Duration {
+	d := make([]time.Duration, len(r.samples))
+	for i, s := range r.samples {
+		d[i] = s.offset
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	return percentile(d, 50)
+}
+
+// percentile returns the p-th percentile of sorted using nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func measureSkew(addr string, clientId string, samples int, interval time.Duration) *skewReport {
+	r := &skewReport{addr: addr}
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		r.errors = append(r.errors, err)
+		return r
+	}
+	defer conn.Close()
+
+	c := api.NewEchoClient(conn)
+	for i := 0; i < samples; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		t0 := time.Now()
+		resp, err := c.Echo(ctx, &api.EchoRequest{ClientId: clientId})
+		t1 := time.Now()
+		cancel()
+		if err != nil {
+			r.errors = append(r.errors, err)
+			continue
+		}
+
+		rtt := t1.Sub(t0)
+		mid := t0.UnixNano() + int64(rtt/2)
+		r.serverId = resp.ServerId
+		r.samples = append(r.samples, skewSample{
+			rtt:    rtt,
+			offset: time.Duration(resp.ClockNanos - mid),
+		})
+	}
+
+	return r
+}
+
+// Skew runs a number of Echo exchanges against every server & reports the
+// estimated clock offset (server - local) & the round trip distribution.
+// Servers whose offset exceeds maxSkew are flagged.
+func (e *echoClient) Skew(samples int, interval time.Duration, maxSkew time.Duration) []*skewReport {
+	reports := make([]*skewReport, len(e.servers))
+	var wg sync.WaitGroup
+	for i, addr := range e.servers {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			reports[i] = measureSkew(addr, e.clientId, samples, interval)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tSERVER_ID\tOK\tERR\tOFFSET\tBOUND\tMEDIAN_OFFSET\tRTT_MIN\tRTT_P50\tRTT_P90\tRTT_MAX\tSTATUS")
+	var lo, hi time.Duration
+	n := 0
+	for _, r := range reports {
+		if len(r.samples) == 0 {
+			fmt.Fprintf(w, "%s\t-\t0\t%d\t-\t-\t-\t-\t-\t-\t-\tUNREACHABLE\n", r.addr, len(r.errors))
+			continue
+		}
+
+		b := r.best()
+		rtts := r.rtts()
+		state := "OK"
+		if abs(b.offset) > maxSkew {
+			state = "SKEWED"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%v\t±%v\t%v\t%v\t%v\t%v\t%v\t%s\n",
+			r.addr, r.serverId, len(r.samples), len(r.errors),
+			b.offset, b.rtt/2, r.medianOffset(),
+			rtts[0], percentile(rtts, 50), percentile(rtts, 90), rtts[len(rtts)-1],
+			state)
+
+		if n == 0 || b.offset < lo {
+			lo = b.offset
+		}
+		if n == 0 || b.offset > hi {
+			hi = b.offset
+		}
+		n++
+	}
+	w.Flush()
+
+	if n > 1 {
+		fmt.Printf("\nmax skew between servers = %v (threshold %v)\n", hi-lo, maxSkew)
+	}
+
+	for _, r := range reports {
+		for _, err := range r.errors {
+			logger.Warn("skew sample failed", "addr", r.addr, "err", err)
+		}
+	}
+	return reports
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func runSkew(cli *echoClient, argv []string) {
+	usage := `usage: client skew [--samples=<n>] [--interval=<interval>] [--max-skew=<max-skew>]
+
+options:
+   --samples=<n>            Exchanges per server [default: 16].
+   --interval=<interval>    Pause between exchanges [default: 100ms].
+   --max-skew=<max-skew>    Flag servers w/ a larger clock offset [default: 50ms].
+`
+	parser := &docopt.Parser{}
+	args, err := parser.ParseArgs(usage, argv, "1.0")
+	if err != nil {
+		logger.Error("invalid arguments", "err", err)
+		return
+	}
+
+	samples, err := args.Int("--samples")
+	if err == nil && samples < 1 {
+		err = fmt.Errorf("--samples = %d must be at least 1", samples)
+	}
+	if err != nil {
+		logger.Error("invalid arguments", "err", err)
+		return
+	}
+
+	interval, err := parseDuration(args, "--interval")
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+
+	maxSkew, err := parseDuration(args, "--max-skew")
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+
+	cli.Skew(samples, interval, maxSkew)
+}
+
+func parseDuration(args docopt.Opts, key string) (time.Duration, error) {
+	s, err := args.String(key)
+	if err != nil {
+		return 0, err
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/docopt/docopt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+func runStream(cli *echoClient, argv []string) {
+	usage := `usage: client stream [--count=<n>] [--no-resume]
+
+options:
+   --count=<n>     Stop after this many ticks, 0 runs forever [default: 0].
+   --no-resume     Start over instead of resuming a broken stream.
+
+a broken stream is reopened over the --balancer policy w/ the resume token of
+the last tick, so the server replays the ticks missed meanwhile. Gaps in the
+sequence are reported.
+`
+	parser := &docopt.Parser{}
+	args, err := parser.ParseArgs(usage, argv, "1.0")
+	if err != nil {
+		logger.Error("invalid arguments", "err", err)
+		return
+	}
+
+	count, err := args.Int("--count")
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+	noResume, _ := args.Bool("--no-resume")
+
+	config, err := lbServiceConfig(cli.balancer, cli.balancerConfig, false, false)
+	if err != nil {
+		logger.Error("command failed", "err", err)
+		return
+	}
+
+	conn, cleanup, err := dialServers(cli.servers, config)
+	if err != nil {
+		logger.Error("did not connect", "err", err)
+		return
+	}
+	defer cleanup()
+	defer conn.Close()
+
+	c := api.NewEchoClient(conn)
+	var last *api.EchoResponse
+	received, gaps := 0, 0
+	for count == 0 || received < count {
+		req := &api.EchoRequest{ClientId: cli.clientId}
+		if last != nil && !noResume {
+			req.ResumeToken = last.ResumeToken
+		}
+
+		stream, err := c.StreamEcho(context.Background(), req)
+		for err == nil && (count == 0 || received < count) {
+			var resp *api.EchoResponse
+			if resp, err = stream.Recv(); err != nil {
+				break
+			}
+
+			if last != nil && resp.ServerId == last.ServerId && resp.Sequence != last.Sequence+1 {
+				gaps++
+				logger.Warn("stream gap", "expected", last.Sequence+1, "got", resp.Sequence)
+			}
+			fmt.Printf("server_id = %v seq = %d clock = %v\n", resp.ServerId, resp.Sequence,
+				time.Unix(0, resp.ClockNanos).Format("15:04:05.000"))
+			last = resp
+			received++
+		}
+		if err == nil {
+			break
+		}
+
+		switch status.Code(err) {
+		case codes.OutOfRange, codes.FailedPrecondition:
+			// the ticks since last are gone, or last was of another server
+			logger.Warn("stream cannot resume, restarting", "err", err)
+			last = nil
+		default:
+			logger.Warn("stream broke, reconnecting", "err", err)
+			time.Sleep(time.Second)
+		}
+	}
+
+	fmt.Printf("received = %d gaps = %d\n", received, gaps)
+}
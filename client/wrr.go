@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/serviceconfig"
+	"sync"
+	"time"
+)
+
+const wrrPolicy = "weighted_round_robin"
+
+func init() {
+	balancer.Register(&policyBuilder{
+		name: wrrPolicy,
+		newPickerBuilder: func() configurablePickerBuilder {
+			return &wrrPickerBuilder{cfg: defaultWRRConfig(), loads: make(map[string]*backendLoad)}
+		},
+		parseConfig: parseWRRConfig,
+	})
+}
+
+// wrrConfig is the loadBalancingConfig of the weighted_round_robin policy.
+type wrrConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	// how much a fully used cpu adds to the load, in calls
+	CPUWeight float64 `json:"cpuWeight"`
+
+	// how much an open stream adds to the load, in calls
+	StreamWeight float64 `json:"streamWeight"`
+
+	// weight of the newest report in the moving average of the load
+	Smoothing float64 `json:"smoothing"`
+
+	// a backend w/o a report for this long gets the mean weight again
+	WeightExpiration duration `json:"weightExpiration"`
+}
+
+func defaultWRRConfig() *wrrConfig {
+	return &wrrConfig{
+		CPUWeight:        1,
+		StreamWeight:     0,
+		Smoothing:        0.3,
+		WeightExpiration: duration(10 * time.Second),
+	}
+}
+
+func parseWRRConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := defaultWRRConfig()
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case cfg.CPUWeight < 0 || cfg.StreamWeight < 0:
+		return nil, fmt.Errorf("cpuWeight & streamWeight must not be negative")
+	case cfg.Smoothing <= 0 || cfg.Smoothing > 1:
+		return nil, fmt.Errorf("smoothing = %v must be in (0, 1]", cfg.Smoothing)
+	case cfg.WeightExpiration <= 0:
+		return nil, fmt.Errorf("weightExpiration must be positive")
+	}
+	return cfg, nil
+}
+
+// backendLoad is the smoothed load of a backend address, taken from the
+// load reports in the trailers of the calls made to it.
+type backendLoad struct {
+	mu sync.Mutex
+
+	// moving average of the load, in calls
+	load float64
+
+	updated time.Time
+}
+
+// update folds the report in the trailer md into the load, if there is one.
+func (b *backendLoad) update(md metadata.MD, cfg *wrrConfig) {
+	v := md.Get(api.LoadReportTrailer)
+	if len(v) == 0 {
+		return
+	}
+
+	r := &api.LoadReport{}
+	if err := proto.Unmarshal([]byte(v[0]), r); err != nil {
+		return
+	}
+	load := float64(r.InFlight+r.QueueDepth) +
+		cfg.CPUWeight*r.CpuUtilization +
+		cfg.StreamWeight*float64(r.ActiveStreams)
+	if load < 1 {
+		// the reporting call was in flight itself
+		load = 1
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.updated.IsZero() {
+		b.load = load
+	} else {
+		b.load = cfg.Smoothing*load + (1-cfg.Smoothing)*b.load
+	}
+	b.updated = time.Now()
+}
+
+// weight returns 1 / load, or 0 if there is no recent report.
+func (b *backendLoad) weight(now time.Time, expiration time.Duration) float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.updated.IsZero() || now.Sub(b.updated) > expiration {
+		return 0
+	}
+	return 1 / b.load
+}
+
+// wrrPickerBuilder keeps the load of each backend address across the
+// pickers it builds.
+type wrrPickerBuilder struct {
+	mu sync.Mutex
+
+	cfg *wrrConfig
+
+	loads map[string]*backendLoad
+}
+
+func (b *wrrPickerBuilder) UpdateConfig(cfg serviceconfig.LoadBalancingConfig) {
+	if c, ok := cfg.(*wrrConfig); ok {
+		b.mu.Lock()
+		b.cfg = c
+		b.mu.Unlock()
+	}
+}
+
+func (b *wrrPickerBuilder) Build(info base.PickerBuildInfo) balancer.V2Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPickerV2(balancer.ErrNoSubConnAvailable)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p := &wrrPicker{cfg: b.cfg}
+	for sc, sci := range info.ReadySCs {
+		addr := sci.Address.Addr
+		l, ok := b.loads[addr]
+		if !ok {
+			l = &backendLoad{}
+			b.loads[addr] = l
+		}
+		p.subConns = append(p.subConns, sc)
+		p.loads = append(p.loads, l)
+	}
+	p.current = make([]float64, len(p.subConns))
+	return p
+}
+
+// wrrPicker picks the ready subconns in proportion to the inverse of their
+// reported load, using smooth weighted round robin so that picks of the
+// same backend are spread out. Backends w/o a recent report get the mean
+// weight of the others, all backends are picked evenly w/o any reports.
+type wrrPicker struct {
+	cfg *wrrConfig
+
+	subConns []balancer.SubConn
+
+	loads []*backendLoad
+
+	mu sync.Mutex
+
+	current []float64
+}
+
+func (p *wrrPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	now := time.Now()
+	weights := make([]float64, len(p.loads))
+	sum, known := 0.0, 0
+	for i, l := range p.loads {
+		if weights[i] = l.weight(now, time.Duration(p.cfg.WeightExpiration)); weights[i] > 0 {
+			sum += weights[i]
+			known++
+		}
+	}
+
+	mean := 1.0
+	if known > 0 {
+		mean = sum / float64(known)
+	}
+
+	p.mu.Lock()
+	best, total := 0, 0.0
+	for i, w := range weights {
+		if w == 0 {
+			w = mean
+		}
+		p.current[i] += w
+		total += w
+		if p.current[i] > p.current[best] {
+			best = i
+		}
+	}
+	p.current[best] -= total
+	p.mu.Unlock()
+
+	l := p.loads[best]
+	return balancer.PickResult{
+		SubConn: p.subConns[best],
+		Done: func(di balancer.DoneInfo) {
+			l.update(di.Trailer, p.cfg)
+		},
+	}, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/logging"
+	"github.com/1xyz/grpc-playground/node"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+)
+
+const (
+	stateRunning = "RUNNING"
+
+	statePaused = "PAUSED"
+
+	stateKilled = "KILLED"
+)
+
+// cluster runs several nodes in this process, one of them the leader. It
+// implements the Cluster control service & backs the prompt.
+type cluster struct {
+	api.UnimplementedClusterServer
+
+	mu sync.Mutex
+
+	cfgs []node.Config
+
+	// nil while killed
+	nodes []*node.Node
+
+	leader int
+
+	log *logging.Logger
+}
+
+func newCluster(cfgs []node.Config, leader int, log *logging.Logger) *cluster {
+	return &cluster{cfgs: cfgs, nodes: make([]*node.Node, len(cfgs)), leader: leader, log: log}
+}
+
+// start starts node i, which must not be running. It returns once the node
+// listens, failing when it cannot.
+func (c *cluster) start(i int) error {
+	cfg := c.cfgs[i]
+	cfg.Leader = i == c.leader
+	n, err := node.New(cfg)
+	if err != nil {
+		return err
+	}
+	liss, err := n.Listen()
+	if err != nil {
+		n.Stop()
+		return fmt.Errorf("node %d: %v", i, err)
+	}
+
+	c.nodes[i] = n
+	go func() {
+		if err := n.Serve(liss...); err != nil {
+			c.log.Error("serve node", "node", i, "err", err)
+		}
+	}()
+	return nil
+}
+
+func (c *cluster) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.cfgs {
+		if err := c.start(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cluster) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, n := range c.nodes {
+		if n != nil {
+			n.Stop()
+			c.nodes[i] = nil
+		}
+	}
+}
+
+func (c *cluster) status(i int) *api.NodeStatus {
+	st := &api.NodeStatus{
+		Index:    int32(i),
+		Address:  c.cfgs[i].Address,
+		State:    stateKilled,
+		IsLeader: i == c.leader,
+	}
+	if n := c.nodes[i]; n != nil {
+		st.ServerId = n.ID()
+		st.State = stateRunning
+		if n.Paused() {
+			st.State = statePaused
+		}
+	}
+	return st
+}
+
+// node returns node i, failing when there is none or it is killed & must
+// not be.
+func (c *cluster) node(i int32, running bool) (*node.Node, error) {
+	if i < 0 || int(i) >= len(c.cfgs) {
+		return nil, status.Errorf(codes.InvalidArgument, "no node %d, there are %d", i, len(c.cfgs))
+	}
+	n := c.nodes[i]
+	if running && n == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %d is killed", i)
+	}
+	return n, nil
+}
+
+func (c *cluster) ListNodes(ctx context.Context, e *api.Empty) (*api.ListNodesResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp := &api.ListNodesResponse{}
+	for i := range c.cfgs {
+		resp.Nodes = append(resp.Nodes, c.status(i))
+	}
+	return resp, nil
+}
+
+func (c *cluster) Kill(ctx context.Context, req *api.NodeRequest) (*api.NodeStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.node(req.Index, true)
+	if err != nil {
+		return nil, err
+	}
+	n.Stop()
+	c.nodes[req.Index] = nil
+	return c.status(int(req.Index)), nil
+}
+
+func (c *cluster) Restart(ctx context.Context, req *api.NodeRequest) (*api.NodeStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.node(req.Index, false)
+	if err != nil {
+		return nil, err
+	}
+	if n != nil {
+		n.Stop()
+		c.nodes[req.Index] = nil
+	}
+	if err := c.start(int(req.Index)); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return c.status(int(req.Index)), nil
+}
+
+func (c *cluster) Pause(ctx context.Context, req *api.NodeRequest) (*api.NodeStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.node(req.Index, true)
+	if err != nil {
+		return nil, err
+	}
+	n.Pause()
+	return c.status(int(req.Index)), nil
+}
+
+func (c *cluster) Resume(ctx context.Context, req *api.NodeRequest) (*api.NodeStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.node(req.Index, true)
+	if err != nil {
+		return nil, err
+	}
+	n.Resume()
+	return c.status(int(req.Index)), nil
+}
+
+// Promote makes node i the leader. A killed node is promoted as well & comes
+// back as the leader when restarted.
+func (c *cluster) Promote(ctx context.Context, req *api.NodeRequest) (*api.NodeStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.node(req.Index, false); err != nil {
+		return nil, err
+	}
+	c.leader = int(req.Index)
+	for i, n := range c.nodes {
+		if n != nil {
+			n.SetLeader(i == c.leader)
+		}
+	}
+	return c.status(int(req.Index)), nil
+}
+
+func formatStatus(st *api.NodeStatus) string {
+	leader := ""
+	if st.IsLeader {
+		leader = "leader"
+	}
+	return fmt.Sprintf("%d\t%s\t%s\t%s\t%s\t", st.Index, st.Address, st.State, leader, st.ServerId)
+}
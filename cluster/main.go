@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/endpoint"
+	"github.com/1xyz/grpc-playground/logging"
+	"github.com/1xyz/grpc-playground/node"
+	"github.com/docopt/docopt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+const promptHelp = `commands:
+   status           List the nodes.
+   kill <i>         Stop node i at once.
+   restart <i>      Start node i again as a new server, killing it first if needed.
+   pause <i>        Hold every call of node i.
+   resume <i>       Let the calls of node i through again.
+   promote <i>      Make node i the leader.
+   help             Print this.
+   quit             Stop all nodes & exit.`
+
+// writeResolverFile writes the addresses of the nodes one per line, which
+// is what the client's --resolver-file reads.
+func writeResolverFile(path string, cfgs []node.Config) error {
+	var b strings.Builder
+	b.WriteString("# servers of the cluster command, one per line\n")
+	for _, cfg := range cfgs {
+		b.WriteString(endpoint.DialTarget(cfg.Address) + "\n")
+	}
+	return ioutil.WriteFile(path, []byte(b.String()), 0644)
+}
+
+func printNodes(c *cluster) {
+	resp, _ := c.ListNodes(context.Background(), &api.Empty{})
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tADDRESS\tSTATE\tROLE\tSERVER ID\t")
+	for _, st := range resp.Nodes {
+		fmt.Fprintln(w, formatStatus(st))
+	}
+	w.Flush()
+}
+
+// runPrompt reads commands from stdin until quit or EOF.
+func runPrompt(c *cluster) {
+	ops := map[string]func(context.Context, *api.NodeRequest) (*api.NodeStatus, error){
+		"kill":    c.Kill,
+		"restart": c.Restart,
+		"pause":   c.Pause,
+		"resume":  c.Resume,
+		"promote": c.Promote,
+	}
+
+	sc := bufio.NewScanner(os.Stdin)
+	fmt.Print("cluster> ")
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		switch {
+		case len(fields) == 0:
+
+		case fields[0] == "quit" || fields[0] == "exit":
+			return
+
+		case fields[0] == "status":
+			printNodes(c)
+
+		case fields[0] == "help":
+			fmt.Println(promptHelp)
+
+		case ops[fields[0]] != nil && len(fields) == 2:
+			i, err := strconv.Atoi(fields[1])
+			if err != nil {
+				fmt.Printf("invalid node %q\n", fields[1])
+				break
+			}
+			if _, err := ops[fields[0]](context.Background(), &api.NodeRequest{Index: int32(i)}); err != nil {
+				fmt.Printf("err = %v\n", status.Convert(err).Message())
+				break
+			}
+			printNodes(c)
+
+		default:
+			fmt.Println(promptHelp)
+		}
+		fmt.Print("cluster> ")
+	}
+}
+
+func main() {
+	usage := `usage: cluster [--nodes=<n>] [--host=<host>] [--base-port=<port>] [--unix=<dir>] [--leader=<i>]
+               [--resolver-file=<path>] [--control=<address>] [--no-prompt]
+               [--log-level=<level>] [--log-format=<format>]
+
+options:
+   --nodes=<n>              Number of echo servers [default: 3].
+   --host=<host>            Host the servers listen on [default: localhost].
+   --base-port=<port>       Port of the first server, the others follow [default: 11000].
+   --unix=<dir>             Listen on unix sockets node-<i>.sock in this directory
+                            instead, which avoids port conflicts.
+   --leader=<i>             Node that starts as the leader [default: 0].
+   --resolver-file=<path>   Write the server addresses here [default: cluster.servers].
+   --control=<address>      Serve the Cluster control service on this address, or endpoint.
+   --no-prompt              Do not read commands from stdin, run until interrupted.
+   --log-level=<level>      Log debug, info, warn or error & above, the level is shared
+                            by all nodes [default: info].
+   --log-format=<format>    Log records as logfmt or json [default: logfmt].
+
+all servers are peers of each other & run in this process.
+
+` + promptHelp
+	parser := &docopt.Parser{}
+	args, err := parser.ParseArgs(usage, nil, "1.0")
+	if err != nil {
+		logging.Default().Error("invalid arguments", "err", err)
+		return
+	}
+
+	level, _ := args.String("--log-level")
+	format, _ := args.String("--log-format")
+	logger, err := logging.Setup(level, format)
+	if err != nil {
+		logging.Default().Error("invalid arguments", "err", err)
+		return
+	}
+
+	logger.Debug("parsed arguments", "args", args)
+	count, err := args.Int("--nodes")
+	if err != nil || count < 1 {
+		logger.Error("invalid --nodes")
+		return
+	}
+	basePort, err := args.Int("--base-port")
+	if err != nil {
+		logger.Error("invalid arguments", "err", err)
+		return
+	}
+	leader, err := args.Int("--leader")
+	if err != nil || leader < 0 || leader >= count {
+		logger.Error("invalid --leader")
+		return
+	}
+	host, _ := args.String("--host")
+
+	addrs := make([]string, count)
+	for i := range addrs {
+		addrs[i] = net.JoinHostPort(host, strconv.Itoa(basePort+i))
+	}
+	if dir, err := args.String("--unix"); err == nil {
+		if dir, err = filepath.Abs(dir); err != nil {
+			logger.Error("invalid --unix", "err", err)
+			return
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			logger.Error("invalid --unix", "err", err)
+			return
+		}
+		for i := range addrs {
+			addrs[i] = "unix://" + filepath.Join(dir, fmt.Sprintf("node-%d.sock", i))
+		}
+	}
+	cfgs := make([]node.Config, count)
+	for i := range cfgs {
+		cfgs[i] = node.DefaultConfig()
+		cfgs[i].Address = addrs[i]
+		cfgs[i].Peers = addrs
+		cfgs[i].Logger = logger.With("node", i)
+	}
+
+	c := newCluster(cfgs, leader, logger)
+	if err := c.Start(); err != nil {
+		logger.Error("start cluster", "err", err)
+		c.Stop()
+		return
+	}
+	defer c.Stop()
+
+	path, _ := args.String("--resolver-file")
+	if err := writeResolverFile(path, cfgs); err != nil {
+		logger.Error("write resolver file", "path", path, "err", err)
+		return
+	}
+	logger.Info("started", "nodes", count, "resolver_file", path)
+
+	if addr, err := args.String("--control"); err == nil {
+		lis, err := endpoint.Listen(addr)
+		if err != nil {
+			logger.Error("listen", "addr", addr, "err", err)
+			return
+		}
+
+		s := grpc.NewServer()
+		api.RegisterClusterServer(s, c)
+		defer s.Stop()
+		go s.Serve(lis)
+		logger.Info("serving control service", "addr", addr)
+	}
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	if noPrompt, _ := args.Bool("--no-prompt"); noPrompt {
+		<-interrupted
+		return
+	}
+
+	done := make(chan bool)
+	go func() {
+		runPrompt(c)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-interrupted:
+	}
+}
@@ -0,0 +1,255 @@
+// Package config loads the settings of a command from a YAML or JSON file,
+// the environment & its flags, in that order, on top of its defaults. A
+// config is a struct whose fields are named by their json tags, embedded
+// structs are flattened. Strings, bools, ints, floats, durations like 1s &
+// lists of strings are supported.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ghodss/yaml"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+type field struct {
+	// json name
+	name string
+
+	v reflect.Value
+}
+
+// fields returns the settable fields of the struct v points to.
+func fields(v interface{}) ([]field, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config %T is not a pointer to a struct", v)
+	}
+
+	var fs []field
+	collect(rv.Elem(), &fs)
+	return fs, nil
+}
+
+func collect(s reflect.Value, fs *[]field) {
+	t := s.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		switch {
+		case sf.Anonymous && sf.Type.Kind() == reflect.Struct && name == "":
+			collect(s.Field(i), fs)
+
+		case sf.PkgPath != "" || name == "" || name == "-":
+
+		default:
+			*fs = append(*fs, field{name: name, v: s.Field(i)})
+		}
+	}
+}
+
+// set parses s into f, a list is comma separated.
+func (f field) set(s string) error {
+	v := f.v
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%v = %q is not a duration like 1s or 500ms", f.name, s)
+		}
+		v.SetInt(int64(d))
+
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%v = %q is not true or false", f.name, s)
+		}
+		v.SetBool(b)
+
+	case v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%v = %q is not an integer", f.name, s)
+		}
+		v.SetInt(n)
+
+	case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%v = %q is not a number", f.name, s)
+		}
+		v.SetFloat(x)
+
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		list := make([]string, 0)
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				list = append(list, e)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+
+	default:
+		return fmt.Errorf("%v has unsupported type %v", f.name, v.Type())
+	}
+	return nil
+}
+
+// text returns a value decoded from JSON as the string set parses.
+func text(name string, raw interface{}) (string, error) {
+	switch raw := raw.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return raw, nil
+	case bool:
+		return strconv.FormatBool(raw), nil
+	case float64:
+		return strconv.FormatFloat(raw, 'f', -1, 64), nil
+	case []interface{}:
+		list := make([]string, len(raw))
+		for i, e := range raw {
+			s, err := text(name, e)
+			if err != nil {
+				return "", err
+			}
+			list[i] = s
+		}
+		return strings.Join(list, ","), nil
+	default:
+		return "", fmt.Errorf("%v is not a value or a list", name)
+	}
+}
+
+// Load sets v, a pointer to a config struct, from the YAML or JSON file at
+// path, when not empty, then from the environment variables named
+// envPrefix followed by the upper case name of a field, e.g.
+// ECHO_SERVER_TICK_INTERVAL. Keys of the file that are not fields are an
+// error.
+func Load(v interface{}, path string, envPrefix string) error {
+	fs, err := fields(v)
+	if err != nil {
+		return err
+	}
+
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		js, err := yaml.YAMLToJSON(b)
+		if err != nil {
+			return fmt.Errorf("%v: %v", path, err)
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(js, &m); err != nil {
+			return fmt.Errorf("%v: not a map of settings", path)
+		}
+
+		byName := make(map[string]field)
+		for _, f := range fs {
+			byName[f.name] = f
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			f, ok := byName[k]
+			if !ok {
+				return fmt.Errorf("%v: unknown setting %q", path, k)
+			}
+			s, err := text(k, m[k])
+			if err == nil {
+				err = f.set(s)
+			}
+			if err != nil {
+				return fmt.Errorf("%v: %v", path, err)
+			}
+		}
+	}
+
+	for _, f := range fs {
+		name := EnvName(envPrefix, f.name)
+		if s, ok := os.LookupEnv(name); ok {
+			if err := f.set(s); err != nil {
+				return fmt.Errorf("%v: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// EnvName is the environment variable overriding the setting name.
+func EnvName(envPrefix string, name string) string {
+	return envPrefix + strings.ToUpper(name)
+}
+
+// ApplyFlags sets v from the docopt args given on the command line, the
+// flag of a field is its name w/ dashes, e.g. --tick-interval. Flags w/o a
+// value, as well as false bools, leave the field as is, so docopt defaults
+// would override the file & environment & must not be used for them.
+func ApplyFlags(v interface{}, args map[string]interface{}) error {
+	fs, err := fields(v)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fs {
+		flag := "--" + strings.Replace(f.name, "_", "-", -1)
+		switch a := args[flag].(type) {
+		case string:
+			if err := f.set(a); err != nil {
+				return fmt.Errorf("%v: %v", flag, err)
+			}
+
+		case bool:
+			if a {
+				f.v.SetBool(true)
+			}
+		}
+	}
+	return nil
+}
+
+// Write writes v as YAML in the order of its fields, which Load reads back.
+func Write(w io.Writer, v interface{}) error {
+	fs, err := fields(v)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fs {
+		var value interface{}
+		switch {
+		case f.v.Type() == durationType:
+			value = time.Duration(f.v.Int()).String()
+		case f.v.Kind() == reflect.Slice && f.v.Len() == 0:
+			value = []string{}
+		default:
+			value = f.v.Interface()
+		}
+
+		b, err := yaml.Marshal(map[string]interface{}{f.name: value})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
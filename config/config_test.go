@@ -0,0 +1,246 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testEnvPrefix = "CONFIG_TEST_"
+
+type Embedded struct {
+	Level string `json:"level"`
+}
+
+type testConfig struct {
+	Embedded
+
+	Name string `json:"name"`
+
+	Leader bool `json:"leader"`
+
+	Port int `json:"port"`
+
+	Rate float64 `json:"rate"`
+
+	TickInterval time.Duration `json:"tick_interval"`
+
+	Origins []string `json:"origins"`
+
+	Skipped string `json:"-"`
+
+	hidden string
+}
+
+func defaults() testConfig {
+	return testConfig{
+		Embedded:     Embedded{Level: "info"},
+		Name:         "default",
+		Port:         11000,
+		Rate:         1,
+		TickInterval: time.Second,
+		Origins:      []string{"*"},
+	}
+}
+
+// writeFile writes content to a file in a new temp dir & returns its path
+// & a func removing it.
+func writeFile(t *testing.T, name string, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("temp dir: err = %v", err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write %v: err = %v", path, err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+// setEnv sets the environment variables in env & returns a func unsetting
+// them.
+func setEnv(env map[string]string) func() {
+	for k, v := range env {
+		os.Setenv(testEnvPrefix+k, v)
+	}
+	return func() {
+		for k := range env {
+			os.Unsetenv(testEnvPrefix + k)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+
+		// YAML or JSON, no file when empty
+		file string
+
+		env map[string]string
+
+		flags map[string]interface{}
+
+		// the defaults w/ this applied, nil when loading fails
+		want func(c *testConfig)
+	}{
+		{
+			name: "defaults",
+			want: func(c *testConfig) {},
+		},
+		{
+			name: "yaml file",
+			file: "name: file\nleader: true\nport: 12000\nrate: 0.5\ntick_interval: 250ms\norigins: [a, b]\nlevel: debug\n",
+			want: func(c *testConfig) {
+				c.Name, c.Leader, c.Port, c.Rate, c.TickInterval = "file", true, 12000, 0.5, 250*time.Millisecond
+				c.Origins, c.Level = []string{"a", "b"}, "debug"
+			},
+		},
+		{
+			name: "json file",
+			file: `{"name": "file", "tick_interval": "1m30s", "origins": ["a"]}`,
+			want: func(c *testConfig) {
+				c.Name, c.TickInterval, c.Origins = "file", 90*time.Second, []string{"a"}
+			},
+		},
+		{
+			name: "env over file",
+			file: "name: file\nport: 12000\n",
+			env:  map[string]string{"NAME": "env", "ORIGINS": "x, y,", "TICK_INTERVAL": "2s"},
+			want: func(c *testConfig) {
+				c.Name, c.Port, c.Origins, c.TickInterval = "env", 12000, []string{"x", "y"}, 2*time.Second
+			},
+		},
+		{
+			name:  "flags over env & file",
+			file:  "name: file\nport: 12000\nrate: 0.5\n",
+			env:   map[string]string{"NAME": "env", "PORT": "13000"},
+			flags: map[string]interface{}{"--name": "flag", "--tick-interval": "5s", "--leader": true, "--rate": nil},
+			want: func(c *testConfig) {
+				c.Name, c.Port, c.Rate, c.TickInterval, c.Leader = "flag", 13000, 0.5, 5*time.Second, true
+			},
+		},
+		{
+			name:  "false bool flag leaves the file",
+			file:  "leader: true\n",
+			flags: map[string]interface{}{"--leader": false},
+			want:  func(c *testConfig) { c.Leader = true },
+		},
+		{
+			name: "empty list",
+			file: "origins: []\n",
+			want: func(c *testConfig) { c.Origins = []string{} },
+		},
+		{name: "unknown key", file: "name: file\nnmae: typo\n"},
+		{name: "field named -", file: "Skipped: x\n"},
+		{name: "unexported field", file: "hidden: x\n"},
+		{name: "not a map", file: "- a\n- b\n"},
+		{name: "nested map", file: "name: {a: b}\n"},
+		{name: "bad duration", file: "tick_interval: 10\n"},
+		{name: "bad duration in env", env: map[string]string{"TICK_INTERVAL": "soon"}},
+		{name: "bad int", file: "port: 1.5\n"},
+		{name: "bad bool in env", env: map[string]string{"LEADER": "yes please"}},
+		{name: "bad float flag", flags: map[string]interface{}{"--rate": "fast"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var path string
+			if tc.file != "" {
+				p, remove := writeFile(t, "config.yaml", tc.file)
+				defer remove()
+				path = p
+			}
+			defer setEnv(tc.env)()
+
+			got := defaults()
+			err := Load(&got, path, testEnvPrefix)
+			if err == nil {
+				err = ApplyFlags(&got, tc.flags)
+			}
+
+			if tc.want == nil {
+				if err == nil {
+					t.Fatalf("config = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			want := defaults()
+			tc.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("config = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadNotAStruct(t *testing.T) {
+	var c testConfig
+	for _, v := range []interface{}{c, new(int), nil} {
+		if err := Load(v, "", testEnvPrefix); err == nil {
+			t.Errorf("Load(%T) err = nil, want an error", v)
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if got := EnvName("ECHO_SERVER_", "tick_interval"); got != "ECHO_SERVER_TICK_INTERVAL" {
+		t.Fatalf("EnvName = %v, want ECHO_SERVER_TICK_INTERVAL", got)
+	}
+}
+
+// TestWriteLoad checks that Load reads back what Write wrote, in the order
+// of the fields.
+func TestWriteLoad(t *testing.T) {
+	for _, c := range []testConfig{
+		defaults(),
+		{},
+		{
+			Embedded:     Embedded{Level: "debug"},
+			Name:         "a: b # c",
+			Leader:       true,
+			Port:         -1,
+			Rate:         0.125,
+			TickInterval: 1500 * time.Millisecond,
+			Origins:      []string{"http://a:1", "*"},
+		},
+	} {
+		var b bytes.Buffer
+		if err := Write(&b, &c); err != nil {
+			t.Fatalf("write %+v: err = %v", c, err)
+		}
+
+		var keys []string
+		for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+			if !strings.HasPrefix(line, "-") && !strings.HasPrefix(line, " ") {
+				keys = append(keys, strings.SplitN(line, ":", 2)[0])
+			}
+		}
+		if want := []string{"level", "name", "leader", "port", "rate", "tick_interval", "origins"}; !reflect.DeepEqual(keys, want) {
+			t.Fatalf("keys = %v, want %v", keys, want)
+		}
+
+		path, remove := writeFile(t, "config.yaml", b.String())
+		var got testConfig
+		err := Load(&got, path, testEnvPrefix)
+		remove()
+		if err != nil {
+			t.Fatalf("load %q: err = %v", b.String(), err)
+		}
+
+		want := c
+		if want.Origins == nil {
+			// an empty list is written as []
+			want.Origins = []string{}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("config = %+v, want %+v from %q", got, want, b.String())
+		}
+	}
+}
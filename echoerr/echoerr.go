@@ -0,0 +1,231 @@
+// Package echoerr carries typed errors from the echo servers to their
+// clients in the details of a gRPC status. ErrorInfo says why a call failed,
+// RetryInfo when to try it again, QuotaFailure which quota ran out &
+// DebugInfo what the server saw.
+//
+// Servers return an *Error like any other error, grpc sends it w/ its
+// details. Clients get it back w/ FromError & branch on its Reason.
+//
+// Whether & when to retry also goes in the PushbackTrailer, which grpc
+// clients w/ a retry policy wait by instead of their own backoff.
+package echoerr
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"time"
+)
+
+// Domain is the ErrorInfo domain of the errors of the echo servers.
+const Domain = "grpc-playground.1xyz.github.com"
+
+// PushbackTrailer holds how many milliseconds a client should wait before
+// retrying a failed call, a negative value asks it not to retry at all.
+const PushbackTrailer = "grpc-retry-pushback-ms"
+
+// Reasons of the errors of the echo servers.
+const (
+	// FailingEcho fails every call, on purpose
+	ReasonFailingEcho = "FAILING_ECHO"
+
+	// the rate limit of the caller is exhausted, see QuotaViolations
+	ReasonRateLimited = "RATE_LIMITED"
+
+	// the call was failed by the faults setting of the server
+	ReasonInjectedFault = "INJECTED_FAULT"
+
+	// a call forwarded to the leader reached a server that is not the
+	// leader anymore
+	ReasonNotLeader = "NOT_LEADER"
+)
+
+// QuotaViolation is a quota the call ran out of.
+type QuotaViolation struct {
+	// what the quota is counted for, e.g. client:<client_id>
+	Subject string
+
+	Description string
+}
+
+// Error is a gRPC status w/ its details decoded.
+type Error struct {
+	Code codes.Code
+
+	Message string
+
+	// ErrorInfo, empty w/o it
+	Reason string
+
+	Domain string
+
+	Metadata map[string]string
+
+	// set when the server sent RetryInfo, i.e. it asks to retry the call
+	// after RetryDelay
+	Retry bool
+
+	RetryDelay time.Duration
+
+	// set when the server asks not to retry the call, only sent in the
+	// PushbackTrailer
+	NoRetry bool
+
+	QuotaViolations []QuotaViolation
+
+	// DebugInfo
+	Debug string
+
+	StackEntries []string
+}
+
+func (e *Error) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("%v: %v", e.Code, e.Message)
+	}
+	return fmt.Sprintf("%v: %v (%v)", e.Code, e.Message, e.Reason)
+}
+
+// GRPCStatus returns e as a status, which is what grpc sends for e.
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(e.Code, e.Message)
+
+	var details []proto.Message
+	if e.Reason != "" {
+		details = append(details, &errdetails.ErrorInfo{Reason: e.Reason, Domain: e.Domain, Metadata: e.Metadata})
+	}
+	if e.Retry {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(e.RetryDelay)})
+	}
+	if len(e.QuotaViolations) > 0 {
+		qf := &errdetails.QuotaFailure{}
+		for _, v := range e.QuotaViolations {
+			qf.Violations = append(qf.Violations, &errdetails.QuotaFailure_Violation{
+				Subject:     v.Subject,
+				Description: v.Description,
+			})
+		}
+		details = append(details, qf)
+	}
+	if e.Debug != "" || len(e.StackEntries) > 0 {
+		details = append(details, &errdetails.DebugInfo{Detail: e.Debug, StackEntries: e.StackEntries})
+	}
+	if len(details) == 0 {
+		return st
+	}
+
+	ds, err := st.WithDetails(details...)
+	if err != nil {
+		return st
+	}
+	return ds
+}
+
+// Pushback returns the value of the PushbackTrailer for e, false when e
+// leaves retries to the client.
+func (e *Error) Pushback() (string, bool) {
+	switch {
+	case e.NoRetry:
+		return "-1", true
+	case e.Retry:
+		ms := (e.RetryDelay + time.Millisecond - 1) / time.Millisecond
+		return strconv.FormatInt(int64(ms), 10), true
+	default:
+		return "", false
+	}
+}
+
+// FromError returns err as an *Error, decoding the details of its status.
+// It returns false when err is nil or not a status. Unknown details are
+// skipped.
+func FromError(err error) (*Error, bool) {
+	if err == nil {
+		return nil, false
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return nil, false
+	}
+
+	e = &Error{Code: st.Code(), Message: st.Message()}
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			e.Reason, e.Domain, e.Metadata = d.Reason, d.Domain, d.Metadata
+
+		case *errdetails.RetryInfo:
+			if delay, err := ptypes.Duration(d.RetryDelay); err == nil {
+				e.Retry, e.RetryDelay = true, delay
+			}
+
+		case *errdetails.QuotaFailure:
+			for _, v := range d.Violations {
+				e.QuotaViolations = append(e.QuotaViolations, QuotaViolation{
+					Subject:     v.Subject,
+					Description: v.Description,
+				})
+			}
+
+		case *errdetails.DebugInfo:
+			e.Debug, e.StackEntries = d.Detail, d.StackEntries
+		}
+	}
+	return e, true
+}
+
+// FromCall is FromError w/ the PushbackTrailer of the call, taken from
+// trailer, applied on top of the details.
+func FromCall(err error, trailer metadata.MD) (*Error, bool) {
+	e, ok := FromError(err)
+	if !ok {
+		return nil, false
+	}
+	if delay, retry, ok := ParsePushback(trailer); ok {
+		c := *e
+		c.Retry, c.NoRetry, c.RetryDelay = retry, !retry, delay
+		return &c, true
+	}
+	return e, true
+}
+
+// ParsePushback returns the delay the PushbackTrailer of md asks for &
+// whether it asks for a retry at all, false w/o the trailer. Like grpc, it
+// takes a value that is not a number of milliseconds as no retry.
+func ParsePushback(md metadata.MD) (delay time.Duration, retry bool, ok bool) {
+	v := md.Get(PushbackTrailer)
+	if len(v) == 0 {
+		return 0, false, false
+	}
+	ms, err := strconv.Atoi(v[0])
+	if len(v) > 1 || err != nil || ms < 0 {
+		return 0, false, true
+	}
+	return time.Duration(ms) * time.Millisecond, true, true
+}
+
+// Reason returns the ErrorInfo reason of err, empty w/o one.
+func Reason(err error) string {
+	if e, ok := FromError(err); ok {
+		return e.Reason
+	}
+	return ""
+}
+
+// RetryDelay returns how long the server asked to wait before retrying the
+// call that failed w/ err, false when it did not ask for a retry.
+func RetryDelay(err error) (time.Duration, bool) {
+	if e, ok := FromError(err); ok && e.Retry {
+		return e.RetryDelay, true
+	}
+	return 0, false
+}
@@ -0,0 +1,287 @@
+// Package echotest runs echo nodes on in-memory bufconn listeners & dials
+// them through a manual resolver, so that client behaviour like retries,
+// health checked balancing & stream resumption can be covered by go tests
+// w/o opening a port. Leadership can be flipped, nodes killed, restarted or
+// paused & faults injected per method while a test runs.
+package echotest
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/node"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+)
+
+const (
+	bufSize = 1 << 20
+
+	// scheme of the manual resolver Dial wires in
+	Scheme = "echotest"
+)
+
+// Main runs the tests of m w/ GRPC_GO_RETRY=on, call it from TestMain when
+// the tests rely on retry policies. grpc only reads the variable when it is
+// initialised, so the test binary is run again w/ it set.
+func Main(m *testing.M) {
+	if os.Getenv("GRPC_GO_RETRY") == "on" {
+		os.Exit(m.Run())
+	}
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Env = append(os.Environ(), "GRPC_GO_RETRY=on")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		fmt.Fprintf(os.Stderr, "echotest: err = %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Cluster is a set of nodes named node-0, node-1, ... that are peers of each
+// other. Node 0 starts as the leader.
+type Cluster struct {
+	t testing.TB
+
+	mu sync.Mutex
+
+	cfgs []node.Config
+
+	// nil while killed
+	nodes []*node.Node
+
+	listeners []*bufconn.Listener
+
+	faults []*Faults
+
+	leader int
+
+	conns []*grpc.ClientConn
+}
+
+// NewCluster starts n nodes, configure is called w/ each node's config
+// before it is started, it may be nil. Stop the cluster when done.
+func NewCluster(t testing.TB, n int, configure func(i int, cfg *node.Config)) *Cluster {
+	c := &Cluster{
+		t:         t,
+		cfgs:      make([]node.Config, n),
+		nodes:     make([]*node.Node, n),
+		listeners: make([]*bufconn.Listener, n),
+		faults:    make([]*Faults, n),
+	}
+
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = c.Addr(i)
+	}
+	for i := range c.cfgs {
+		cfg := node.DefaultConfig()
+		cfg.Address = addrs[i]
+		cfg.Peers = addrs
+		if configure != nil {
+			configure(i, &cfg)
+		}
+
+		c.faults[i] = newFaults()
+		cfg.UnaryInterceptors = append(cfg.UnaryInterceptors, c.faults[i].UnaryInterceptor)
+		cfg.StreamInterceptors = append(cfg.StreamInterceptors, c.faults[i].StreamInterceptor)
+		cfg.DialOptions = append(cfg.DialOptions, grpc.WithContextDialer(c.dial))
+		c.cfgs[i] = cfg
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.cfgs {
+		if err := c.start(i); err != nil {
+			c.stopNodes()
+			t.Fatalf("echotest: node %d err = %v", i, err)
+		}
+	}
+	return c
+}
+
+// Addr is the address node i is dialed at.
+func (c *Cluster) Addr(i int) string {
+	return fmt.Sprintf("node-%d", i)
+}
+
+// start starts node i on a new listener, it must not be running.
+func (c *Cluster) start(i int) error {
+	cfg := c.cfgs[i]
+	cfg.Leader = i == c.leader
+	n, err := node.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	lis := bufconn.Listen(bufSize)
+	c.nodes[i] = n
+	c.listeners[i] = lis
+	go n.Serve(lis)
+	return nil
+}
+
+// dial connects to the listener of the node at addr.
+func (c *Cluster) dial(ctx context.Context, addr string) (net.Conn, error) {
+	c.mu.Lock()
+	var lis *bufconn.Listener
+	for i := range c.cfgs {
+		if c.Addr(i) == addr && c.nodes[i] != nil {
+			lis = c.listeners[i]
+		}
+	}
+	c.mu.Unlock()
+
+	if lis == nil {
+		return nil, fmt.Errorf("echotest: no node at %v", addr)
+	}
+	return lis.Dial()
+}
+
+// Dial connects to all nodes through the manual resolver, using the service
+// config given, e.g. a balancer, health checking or a retry policy. The
+// conn is closed by Stop.
+func (c *Cluster) Dial(serviceConfig string, opts ...grpc.DialOption) *grpc.ClientConn {
+	r := manual.NewBuilderWithScheme(Scheme)
+	state := resolver.State{}
+	for i := range c.cfgs {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: c.Addr(i)})
+	}
+	r.InitialState(state)
+
+	opts = append([]grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithResolvers(r),
+		grpc.WithContextDialer(c.dial),
+	}, opts...)
+	if serviceConfig != "" {
+		opts = append(opts, grpc.WithDefaultServiceConfig(serviceConfig))
+	}
+	return c.dialTarget(Scheme+":///cluster", opts)
+}
+
+// DialNode connects to node i only.
+func (c *Cluster) DialNode(i int, opts ...grpc.DialOption) *grpc.ClientConn {
+	opts = append([]grpc.DialOption{grpc.WithInsecure(), grpc.WithContextDialer(c.dial)}, opts...)
+	return c.dialTarget("passthrough:///"+c.Addr(i), opts)
+}
+
+func (c *Cluster) dialTarget(target string, opts []grpc.DialOption) *grpc.ClientConn {
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		c.t.Fatalf("echotest: dial %v err = %v", target, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conns = append(c.conns, conn)
+	return conn
+}
+
+// Node returns node i, nil while it is killed.
+func (c *Cluster) Node(i int) *node.Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nodes[i]
+}
+
+// Faults returns the faults injected into node i, they outlive restarts.
+func (c *Cluster) Faults(i int) *Faults {
+	return c.faults[i]
+}
+
+// Leader is the index of the leader.
+func (c *Cluster) Leader() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leader
+}
+
+// Promote makes node i the leader & demotes the others. A killed node comes
+// back as the leader when restarted.
+func (c *Cluster) Promote(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.leader = i
+	for j, n := range c.nodes {
+		if n != nil {
+			n.SetLeader(j == i)
+		}
+	}
+}
+
+// Kill stops node i at once, dialing it fails until it is restarted.
+func (c *Cluster) Kill(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n := c.nodes[i]; n != nil {
+		n.Stop()
+		c.nodes[i] = nil
+	}
+}
+
+// Restart starts node i again as a new server, killing it first if needed.
+func (c *Cluster) Restart(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n := c.nodes[i]; n != nil {
+		n.Stop()
+		c.nodes[i] = nil
+	}
+	if err := c.start(i); err != nil {
+		c.t.Fatalf("echotest: node %d err = %v", i, err)
+	}
+}
+
+// Pause holds every call of node i until Resume.
+func (c *Cluster) Pause(i int) {
+	if n := c.Node(i); n != nil {
+		n.Pause()
+	}
+}
+
+func (c *Cluster) Resume(i int) {
+	if n := c.Node(i); n != nil {
+		n.Resume()
+	}
+}
+
+// Stop closes the conns dialed & stops all nodes.
+func (c *Cluster) Stop() {
+	c.mu.Lock()
+	conns := c.conns
+	c.conns = nil
+	c.mu.Unlock()
+
+	// closed w/o the lock, their dialers take it
+	for _, conn := range conns {
+		conn.Close()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopNodes()
+}
+
+func (c *Cluster) stopNodes() {
+	for i, n := range c.nodes {
+		if n != nil {
+			n.Stop()
+			c.nodes[i] = nil
+		}
+	}
+}
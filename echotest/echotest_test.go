@@ -0,0 +1,532 @@
+package echotest_test
+
+import (
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/echoerr"
+	"github.com/1xyz/grpc-playground/echotest"
+	"github.com/1xyz/grpc-playground/node"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/health"
+	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	echoMethod = "/api.Echo/Echo"
+
+	retryConfig = `{
+  "methodConfig": [{
+    "name": [{"service": "api.Echo"}],
+    "retryPolicy": {
+      "maxAttempts": 3,
+      "initialBackoff": "0.01s",
+      "maxBackoff": "0.05s",
+      "backoffMultiplier": 2,
+      "retryableStatusCodes": ["UNAVAILABLE"]
+    }
+  }]
+}`
+
+	healthConfig = `{
+  "loadBalancingConfig": [{"round_robin": {}}],
+  "healthCheckConfig": {"serviceName": ""}
+}`
+)
+
+func TestMain(m *testing.M) {
+	echotest.Main(m)
+}
+
+func fastTicks(i int, cfg *node.Config) {
+	cfg.TickInterval = 10 * time.Millisecond
+	cfg.HealthInterval = 10 * time.Millisecond
+}
+
+func echo(t *testing.T, c api.EchoClient, opts ...grpc.CallOption) (*api.EchoResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return c.Echo(ctx, &api.EchoRequest{ClientId: t.Name()}, opts...)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	c := echotest.NewCluster(t, 1, nil)
+	defer c.Stop()
+	client := api.NewEchoClient(c.Dial(retryConfig))
+	faults := c.Faults(0)
+
+	faults.FailNext(echoMethod, 2, codes.Unavailable)
+	if _, err := echo(t, client); err != nil {
+		t.Fatalf("err = %v, want success on the 3rd attempt", err)
+	}
+	if n := faults.Calls(echoMethod); n != 3 {
+		t.Fatalf("calls = %d, want 3", n)
+	}
+
+	faults.Reset()
+	faults.FailNext(echoMethod, 3, codes.Unavailable)
+	if _, err := echo(t, client); status.Code(err) != codes.Unavailable {
+		t.Fatalf("err = %v, want Unavailable once attempts run out", err)
+	}
+	if n := faults.Calls(echoMethod); n != 3 {
+		t.Fatalf("calls = %d, want 3", n)
+	}
+
+	faults.Reset()
+	faults.FailNext(echoMethod, 1, codes.Internal)
+	if _, err := echo(t, client); status.Code(err) != codes.Internal {
+		t.Fatalf("err = %v, want Internal w/o a retry", err)
+	}
+	if n := faults.Calls(echoMethod); n != 1 {
+		t.Fatalf("calls = %d, want 1", n)
+	}
+}
+
+// waitForServer echoes until a response comes from id or the timeout passes.
+func waitForServer(t *testing.T, client api.EchoClient, id string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := echo(t, client, grpc.WaitForReady(true))
+		if err != nil {
+			t.Fatalf("err = %v", err)
+		}
+		if resp.ServerId == id {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no response from server id = %v", id)
+}
+
+func assertAllFrom(t *testing.T, client api.EchoClient, id string) {
+	for i := 0; i < 20; i++ {
+		resp, err := echo(t, client, grpc.WaitForReady(true))
+		if err != nil {
+			t.Fatalf("err = %v", err)
+		}
+		if resp.ServerId != id {
+			t.Fatalf("call %d went to server id = %v, want the leader %v", i, resp.ServerId, id)
+		}
+	}
+}
+
+func TestHealthCheckedRoundRobin(t *testing.T) {
+	c := echotest.NewCluster(t, 3, fastTicks)
+	defer c.Stop()
+	client := api.NewEchoClient(c.Dial(healthConfig))
+
+	waitForServer(t, client, c.Node(0).ID())
+	assertAllFrom(t, client, c.Node(0).ID())
+
+	c.Promote(2)
+	waitForServer(t, client, c.Node(2).ID())
+	assertAllFrom(t, client, c.Node(2).ID())
+
+	// the leader comes back as a new server & is picked again
+	c.Kill(2)
+	c.Restart(2)
+	waitForServer(t, client, c.Node(2).ID())
+	assertAllFrom(t, client, c.Node(2).ID())
+}
+
+// recvTicks reads n ticks, failing when a sequence is skipped.
+func recvTicks(t *testing.T, stream api.Echo_StreamEchoClient, n int, last uint64) *api.EchoResponse {
+	var resp *api.EchoResponse
+	for i := 0; i < n; i++ {
+		var err error
+		resp, err = stream.Recv()
+		if err != nil {
+			t.Fatalf("err = %v", err)
+		}
+		if last != 0 && resp.Sequence != last+1 {
+			t.Fatalf("sequence = %d after %d", resp.Sequence, last)
+		}
+		last = resp.Sequence
+	}
+	return resp
+}
+
+func TestStreamResume(t *testing.T) {
+	c := echotest.NewCluster(t, 1, fastTicks)
+	defer c.Stop()
+	client := api.NewEchoClient(c.DialNode(0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.StreamEcho(ctx, &api.EchoRequest{ClientId: t.Name()})
+	if err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	last := recvTicks(t, stream, 5, 0)
+	cancel()
+
+	// miss a few ticks, the default buffer holds them all
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err = client.StreamEcho(ctx, &api.EchoRequest{ClientId: t.Name(), ResumeToken: last.ResumeToken})
+	if err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	recvTicks(t, stream, 20, last.Sequence)
+}
+
+func TestStreamResumeEvicted(t *testing.T) {
+	c := echotest.NewCluster(t, 1, func(i int, cfg *node.Config) {
+		fastTicks(i, cfg)
+		cfg.StreamBuffer = 2
+	})
+	defer c.Stop()
+	client := api.NewEchoClient(c.DialNode(0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.StreamEcho(ctx, &api.EchoRequest{ClientId: t.Name()})
+	if err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	last := recvTicks(t, stream, 1, 0)
+	cancel()
+
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err = client.StreamEcho(ctx, &api.EchoRequest{ClientId: t.Name(), ResumeToken: last.ResumeToken})
+	if err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.OutOfRange {
+		t.Fatalf("err = %v, want OutOfRange", err)
+	}
+}
+
+func TestStreamResumeOtherServer(t *testing.T) {
+	c := echotest.NewCluster(t, 1, fastTicks)
+	defer c.Stop()
+	client := api.NewEchoClient(c.DialNode(0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.StreamEcho(ctx, &api.EchoRequest{ClientId: t.Name()})
+	if err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	last := recvTicks(t, stream, 1, 0)
+
+	c.Restart(0)
+	stream, err = client.StreamEcho(ctx, &api.EchoRequest{ClientId: t.Name(), ResumeToken: last.ResumeToken},
+		grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("err = %v, want FailedPrecondition", err)
+	}
+}
+
+func TestPause(t *testing.T) {
+	c := echotest.NewCluster(t, 1, nil)
+	defer c.Stop()
+	client := api.NewEchoClient(c.DialNode(0))
+	if _, err := echo(t, client); err != nil {
+		t.Fatalf("err = %v", err)
+	}
+
+	c.Pause(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Echo(ctx, &api.EchoRequest{ClientId: t.Name()}); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("err = %v, want DeadlineExceeded while paused", err)
+	}
+
+	c.Resume(0)
+	if _, err := echo(t, client); err != nil {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	c := echotest.NewCluster(t, 1, nil)
+	defer c.Stop()
+	client := api.NewEchoClient(c.DialNode(0))
+
+	c.Faults(0).Delay(echoMethod, time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Echo(ctx, &api.EchoRequest{ClientId: t.Name()}); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+}
+
+const (
+	failingEchoMethod = "/api.Echo/FailingEcho"
+
+	// retries ResourceExhausted as well, w/ a backoff far shorter than the
+	// pushbacks of the tests
+	exhaustedRetryConfig = `{
+  "methodConfig": [{
+    "name": [{"service": "api.Echo"}],
+    "retryPolicy": {
+      "maxAttempts": 3,
+      "initialBackoff": "0.01s",
+      "maxBackoff": "0.05s",
+      "backoffMultiplier": 2,
+      "retryableStatusCodes": ["UNAVAILABLE", "RESOURCE_EXHAUSTED"]
+    }
+  }]
+}`
+)
+
+// assertGaps fails unless every call after the first came at least want
+// after the one before. There is no upper bound, a busy machine may delay
+// any attempt.
+func assertGaps(t *testing.T, times []time.Time, want time.Duration) {
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < want {
+			t.Fatalf("attempt %d came %v after the one before, want at least %v", i+1, gap, want)
+		}
+	}
+}
+
+// attempts counts the attempts of the calls of a client conn, each is a
+// stream of its own, whether it reaches the handler or not.
+type attempts struct {
+	n int32
+}
+
+func (a *attempts) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (a *attempts) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if _, ok := s.(*stats.OutHeader); ok {
+		atomic.AddInt32(&a.n, 1)
+	}
+}
+
+func (a *attempts) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (a *attempts) HandleConn(context.Context, stats.ConnStats) {}
+
+// take returns the attempts counted since the last take.
+func (a *attempts) take() int {
+	return int(atomic.SwapInt32(&a.n, 0))
+}
+
+func TestFailingEchoPushback(t *testing.T) {
+	for _, tc := range []struct {
+		pushback time.Duration
+
+		wantAttempts int
+
+		wantTrailer string
+	}{
+		{pushback: 0, wantAttempts: 3, wantTrailer: "0"},
+		{pushback: 100 * time.Millisecond, wantAttempts: 3, wantTrailer: "100"},
+		{pushback: 300 * time.Millisecond, wantAttempts: 3, wantTrailer: "300"},
+		{pushback: -time.Second, wantAttempts: 1, wantTrailer: "-1"},
+	} {
+		t.Run(tc.pushback.String(), func(t *testing.T) {
+			c := echotest.NewCluster(t, 1, func(i int, cfg *node.Config) {
+				cfg.FailingEchoPushback = tc.pushback
+			})
+			defer c.Stop()
+			client := api.NewEchoClient(c.Dial(retryConfig))
+
+			var trailer metadata.MD
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err := client.FailingEcho(ctx, &api.EchoRequest{ClientId: t.Name()}, grpc.Trailer(&trailer))
+			if status.Code(err) != codes.Unavailable {
+				t.Fatalf("err = %v, want Unavailable", err)
+			}
+			if v := trailer.Get(echoerr.PushbackTrailer); len(v) != 1 || v[0] != tc.wantTrailer {
+				t.Fatalf("pushback = %v, want %v", v, tc.wantTrailer)
+			}
+
+			times := c.Faults(0).Times(failingEchoMethod)
+			if len(times) != tc.wantAttempts {
+				t.Fatalf("attempts = %d, want %d", len(times), tc.wantAttempts)
+			}
+			assertGaps(t, times, tc.pushback)
+
+			e, _ := echoerr.FromCall(err, trailer)
+			if e.Reason != echoerr.ReasonFailingEcho || e.NoRetry != (tc.pushback < 0) ||
+				(tc.pushback >= 0 && (!e.Retry || e.RetryDelay != tc.pushback)) {
+				t.Fatalf("err = %+v, want reason %v & pushback %v", e, echoerr.ReasonFailingEcho, tc.pushback)
+			}
+		})
+	}
+}
+
+func TestFaultPushback(t *testing.T) {
+	c := echotest.NewCluster(t, 1, nil)
+	defer c.Stop()
+	var a attempts
+	client := api.NewEchoClient(c.Dial(retryConfig, grpc.WithStatsHandler(&a)))
+	n := c.Node(0)
+
+	for _, tc := range []struct {
+		faults string
+
+		wantAttempts int
+
+		wantTrailer string
+
+		// least time the call takes, the attempts all failing
+		min time.Duration
+	}{
+		{faults: "Echo=UNAVAILABLE:1:200ms", wantAttempts: 3, wantTrailer: "200", min: 400 * time.Millisecond},
+		{faults: "Echo=UNAVAILABLE:1:never", wantAttempts: 1, wantTrailer: "-1"},
+	} {
+		cfg := n.Config()
+		cfg.Faults = tc.faults
+		if _, err := n.Reload(cfg); err != nil {
+			t.Fatalf("reload %v: err = %v", tc.faults, err)
+		}
+		a.take()
+
+		var trailer metadata.MD
+		start := time.Now()
+		_, err := echo(t, client, grpc.Trailer(&trailer))
+		if took := time.Since(start); took < tc.min {
+			t.Fatalf("%v: call took %v, want at least %v", tc.faults, took, tc.min)
+		}
+		if echoerr.Reason(err) != echoerr.ReasonInjectedFault {
+			t.Fatalf("%v: err = %v, want an injected fault", tc.faults, err)
+		}
+		if v := trailer.Get(echoerr.PushbackTrailer); len(v) != 1 || v[0] != tc.wantTrailer {
+			t.Fatalf("%v: pushback = %v, want %v", tc.faults, v, tc.wantTrailer)
+		}
+		if got := a.take(); got != tc.wantAttempts {
+			t.Fatalf("%v: attempts = %d, want %d", tc.faults, got, tc.wantAttempts)
+		}
+	}
+}
+
+func TestRateLimitPushback(t *testing.T) {
+	c := echotest.NewCluster(t, 1, func(i int, cfg *node.Config) {
+		cfg.RateLimit = "Echo=2:1"
+	})
+	defer c.Stop()
+	var a attempts
+	client := api.NewEchoClient(c.Dial(exhaustedRetryConfig, grpc.WithStatsHandler(&a)))
+
+	if _, err := echo(t, client); err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	a.take()
+
+	// the bucket refills 500ms after the first call took its token, the
+	// first attempt is limited & the retry waits for the token instead of
+	// backing off for at most 50ms
+	if _, err := echo(t, client); err != nil {
+		t.Fatalf("err = %v, want success once the pushback passed", err)
+	}
+	if got := a.take(); got != 2 {
+		t.Fatalf("attempts = %d, want 2", got)
+	}
+	times := c.Faults(0).Times(echoMethod)
+	if len(times) != 2 {
+		t.Fatalf("calls = %d, want 2, the limited attempt not reaching the handler", len(times))
+	}
+	assertGaps(t, times, 450*time.Millisecond)
+}
+
+func TestRateLimitSparesControlServices(t *testing.T) {
+	c := echotest.NewCluster(t, 1, func(i int, cfg *node.Config) {
+		cfg.RateLimit = "*=0.001:1"
+	})
+	defer c.Stop()
+	conn := c.DialNode(0)
+	client := api.NewEchoClient(conn)
+
+	// w/o a client_id all calls are keyed by the peer, so share a bucket
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Echo(ctx, &api.EchoRequest{}); err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err := client.Echo(ctx, &api.EchoRequest{}); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("err = %v, want ResourceExhausted once the bucket is empty", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := healthgrpc.NewHealthClient(conn).Check(ctx, &healthgrpc.HealthCheckRequest{}); err != nil {
+			t.Fatalf("health check %d: err = %v, want it spared by the * limit", i, err)
+		}
+		if _, err := api.NewAdminClient(conn).GetLogLevel(ctx, &api.Empty{}); err != nil {
+			t.Fatalf("admin call %d: err = %v, want it spared by the * limit", i, err)
+		}
+	}
+}
+
+func TestFaultsSpareControlServices(t *testing.T) {
+	c := echotest.NewCluster(t, 1, func(i int, cfg *node.Config) {
+		cfg.Faults = "*=UNAVAILABLE"
+	})
+	defer c.Stop()
+	conn := c.DialNode(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := api.NewEchoClient(conn).Echo(ctx, &api.EchoRequest{}); echoerr.Reason(err) != echoerr.ReasonInjectedFault {
+		t.Fatalf("err = %v, want an injected fault", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := healthgrpc.NewHealthClient(conn).Check(ctx, &healthgrpc.HealthCheckRequest{}); err != nil {
+			t.Fatalf("health check %d: err = %v, want it spared by the * fault", i, err)
+		}
+		if _, err := api.NewAdminClient(conn).GetLogLevel(ctx, &api.Empty{}); err != nil {
+			t.Fatalf("admin call %d: err = %v, want it spared by the * fault", i, err)
+		}
+	}
+}
+
+func TestMaxConcurrentSparesControlServices(t *testing.T) {
+	c := echotest.NewCluster(t, 1, func(i int, cfg *node.Config) {
+		cfg.MaxConcurrent = 1
+		cfg.RateLimit = "Echo=0.001:1"
+	})
+	defer c.Stop()
+	conn := c.DialNode(0)
+	client := api.NewEchoClient(conn)
+
+	// the first echo holds the only slot
+	c.Faults(0).Delay(echoMethod, 2*time.Second)
+	done := make(chan error, 1)
+	go func() {
+		_, err := echo(t, client)
+		done <- err
+	}()
+	for c.Faults(0).Calls(echoMethod) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		if _, err := healthgrpc.NewHealthClient(conn).Check(ctx, &healthgrpc.HealthCheckRequest{}); err != nil {
+			t.Fatalf("health check %d: err = %v, want it to take no slot", i, err)
+		}
+		if _, err := api.NewAdminClient(conn).GetLogLevel(ctx, &api.Empty{}); err != nil {
+			t.Fatalf("admin call %d: err = %v, want it to take no slot", i, err)
+		}
+	}
+
+	// a limited call fails at once rather than waiting for the slot
+	if _, err := client.Echo(ctx, &api.EchoRequest{ClientId: t.Name()}); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("err = %v, want ResourceExhausted", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("err = %v", err)
+	}
+}
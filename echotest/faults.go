@@ -0,0 +1,113 @@
+package echotest
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+// Faults fails or delays the calls of one node by full method name, e.g.
+// /api.Echo/Echo, & counts the calls that reach it.
+type Faults struct {
+	mu sync.Mutex
+
+	// codes the next calls fail w/, in order
+	fail map[string][]codes.Code
+
+	delay map[string]time.Duration
+
+	calls map[string]int
+
+	// when the calls arrived, in order
+	times map[string][]time.Time
+}
+
+func newFaults() *Faults {
+	f := &Faults{}
+	f.Reset()
+	return f
+}
+
+// FailNext fails the next n calls of method w/ code.
+func (f *Faults) FailNext(method string, n int, code codes.Code) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := 0; i < n; i++ {
+		f.fail[method] = append(f.fail[method], code)
+	}
+}
+
+// Delay holds every call of method for d before it is handled, zero stops
+// delaying.
+func (f *Faults) Delay(method string, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.delay[method] = d
+}
+
+// Calls is the number of calls of method so far, failed ones included.
+func (f *Faults) Calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+// Times returns when the calls of method so far reached the node.
+func (f *Faults) Times(method string) []time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]time.Time(nil), f.times[method]...)
+}
+
+// Reset drops all faults & call counts.
+func (f *Faults) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fail = make(map[string][]codes.Code)
+	f.delay = make(map[string]time.Duration)
+	f.calls = make(map[string]int)
+	f.times = make(map[string][]time.Time)
+}
+
+// inject counts the call & applies the faults of method, returning the error
+// the call fails w/ if any.
+func (f *Faults) inject(ctx context.Context, method string) error {
+	f.mu.Lock()
+	f.calls[method]++
+	f.times[method] = append(f.times[method], time.Now())
+	d := f.delay[method]
+	var err error
+	if pending := f.fail[method]; len(pending) > 0 {
+		err = status.Errorf(pending[0], "echotest: injected fault")
+		f.fail[method] = pending[1:]
+	}
+	f.mu.Unlock()
+
+	if d > 0 {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	return err
+}
+
+func (f *Faults) UnaryInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := f.inject(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (f *Faults) StreamInterceptor(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := f.inject(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
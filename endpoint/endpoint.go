@@ -0,0 +1,129 @@
+// Package endpoint parses the addresses servers listen on & clients dial.
+// An endpoint is one of
+//
+//	host:port              tcp, e.g. :11000 or localhost:11000
+//	tcp://host:port        same, tcp4:// & tcp6:// limit it to IPv4 or IPv6
+//	unix:///path           unix socket at an absolute path, unix:path is relative
+//	unix-abstract:name     Linux abstract unix socket, unix:@name is the same
+//
+// DialTarget turns an endpoint into a target grpc can dial.
+package endpoint
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Parse returns the network & address of endpoint for net.Listen or
+// net.Dial.
+func Parse(endpoint string) (network string, address string, err error) {
+	switch {
+	case endpoint == "":
+		return "", "", fmt.Errorf("empty endpoint")
+
+	case strings.HasPrefix(endpoint, "unix-abstract:"):
+		network, address = "unix", "@"+strings.TrimPrefix(endpoint, "unix-abstract:")
+
+	case strings.HasPrefix(endpoint, "unix://"):
+		// unix:///path, the host part of the URL is empty
+		network, address = "unix", strings.TrimPrefix(endpoint, "unix://")
+		if !strings.HasPrefix(address, "/") {
+			return "", "", fmt.Errorf("endpoint %q is not unix:///absolute/path or unix:path", endpoint)
+		}
+
+	case strings.HasPrefix(endpoint, "unix:"):
+		network, address = "unix", strings.TrimPrefix(endpoint, "unix:")
+
+	default:
+		network, address = "tcp", endpoint
+		for _, n := range []string{"tcp", "tcp4", "tcp6"} {
+			if strings.HasPrefix(endpoint, n+"://") {
+				network, address = n, strings.TrimPrefix(endpoint, n+"://")
+			}
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", fmt.Errorf("endpoint %q: %v", endpoint, err)
+		}
+	}
+
+	if address == "" || address == "@" {
+		return "", "", fmt.Errorf("endpoint %q has no address", endpoint)
+	}
+	return network, address, nil
+}
+
+// Same reports whether endpoints a & b are the same address, e.g. :11000,
+// 0.0.0.0:11000 & tcp://:11000 are. Host names are not resolved.
+func Same(a, b string) bool {
+	na, aa, err := Parse(a)
+	if err != nil {
+		return false
+	}
+	nb, ab, err := Parse(b)
+	if err != nil {
+		return false
+	}
+	return normalize(na, aa) == normalize(nb, ab)
+}
+
+// normalize returns network & address in one form per address. Listening
+// on any host is the same whichever way it is written, tcp4 & tcp6 are tcp
+// as they share its ports.
+func normalize(network, address string) string {
+	if network == "unix" {
+		if !strings.HasPrefix(address, "@") {
+			address = filepath.Clean(address)
+		}
+		return "unix:" + address
+	}
+
+	host, port, _ := net.SplitHostPort(address)
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = ""
+	} else if ip != nil {
+		host = ip.String()
+	}
+	return "tcp:" + net.JoinHostPort(host, port)
+}
+
+// IsUnix reports whether endpoint is a unix socket.
+func IsUnix(endpoint string) bool {
+	network, _, err := Parse(endpoint)
+	return err == nil && network == "unix"
+}
+
+// Listen listens on endpoint. A socket file left behind at the path of a
+// unix endpoint, e.g. by a killed process, is removed first.
+func Listen(endpoint string) (net.Listener, error) {
+	network, address, err := Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if network == "unix" && !strings.HasPrefix(address, "@") {
+		if fi, err := os.Stat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(address); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return net.Listen(network, address)
+}
+
+// DialTarget returns the grpc target of endpoint. grpc dials unix:path &
+// unix:///path over a unix socket & host:port over tcp. Invalid endpoints
+// are returned as is, for grpc to report.
+func DialTarget(endpoint string) string {
+	network, address, err := Parse(endpoint)
+	switch {
+	case err != nil:
+		return endpoint
+	case network == "unix":
+		return "unix:" + address
+	default:
+		return address
+	}
+}
@@ -3,10 +3,17 @@ module github.com/1xyz/grpc-playground
 go 1.13
 
 require (
-	github.com/docopt/docopt-go v0.0.0-20180111231733-ee0de3bc6815 // indirect
+	github.com/desertbit/timer v0.0.0-20180107155436-c41aec40b27f // indirect
+	github.com/docopt/docopt-go v0.0.0-20180111231733-ee0de3bc6815
+	github.com/ghodss/yaml v1.0.0
 	github.com/golang/protobuf v1.4.0
-	github.com/google/uuid v1.1.1 // indirect
-	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e // indirect
+	github.com/google/uuid v1.1.1
+	github.com/gorilla/websocket v1.4.2 // indirect
+	github.com/grpc-ecosystem/grpc-gateway v1.14.4
+	github.com/improbable-eng/grpc-web v0.12.0
+	github.com/rs/cors v1.7.0 // indirect
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
+	google.golang.org/genproto v0.0.0-20200423170343-7949de9c1215
 	google.golang.org/grpc v1.28.1
 	google.golang.org/protobuf v1.21.0
 )
@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"fmt"
+	"google.golang.org/grpc/grpclog"
+	"os"
+	"strings"
+)
+
+// grpcLogger routes the logs of grpc-go into a logger. grpc logs a lot at
+// info, so its info becomes debug here.
+type grpcLogger struct {
+	l *Logger
+}
+
+// NewGrpcLogger returns a grpclog.LoggerV2 writing to l.
+func NewGrpcLogger(l *Logger) grpclog.LoggerV2 {
+	return &grpcLogger{l: l}
+}
+
+func (g *grpcLogger) log(level Level, msg string) {
+	if g.l.Enabled(level) {
+		g.l.Log(level, strings.TrimSuffix(msg, "\n"))
+	}
+}
+
+func (g *grpcLogger) Info(args ...interface{}) { g.log(DebugLevel, fmt.Sprint(args...)) }
+
+func (g *grpcLogger) Infoln(args ...interface{}) { g.log(DebugLevel, fmt.Sprintln(args...)) }
+
+func (g *grpcLogger) Infof(format string, args ...interface{}) {
+	g.log(DebugLevel, fmt.Sprintf(format, args...))
+}
+
+func (g *grpcLogger) Warning(args ...interface{}) { g.log(WarnLevel, fmt.Sprint(args...)) }
+
+func (g *grpcLogger) Warningln(args ...interface{}) { g.log(WarnLevel, fmt.Sprintln(args...)) }
+
+func (g *grpcLogger) Warningf(format string, args ...interface{}) {
+	g.log(WarnLevel, fmt.Sprintf(format, args...))
+}
+
+func (g *grpcLogger) Error(args ...interface{}) { g.log(ErrorLevel, fmt.Sprint(args...)) }
+
+func (g *grpcLogger) Errorln(args ...interface{}) { g.log(ErrorLevel, fmt.Sprintln(args...)) }
+
+func (g *grpcLogger) Errorf(format string, args ...interface{}) {
+	g.log(ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+func (g *grpcLogger) Fatal(args ...interface{}) {
+	g.log(ErrorLevel, fmt.Sprint(args...))
+	os.Exit(1)
+}
+
+func (g *grpcLogger) Fatalln(args ...interface{}) {
+	g.log(ErrorLevel, fmt.Sprintln(args...))
+	os.Exit(1)
+}
+
+func (g *grpcLogger) Fatalf(format string, args ...interface{}) {
+	g.log(ErrorLevel, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// V reports whether grpc's verbose logs are wanted, which they are only at
+// debug.
+func (g *grpcLogger) V(l int) bool {
+	return l <= 0 && g.l.Enabled(DebugLevel)
+}
+
+// Setup makes a logger of level & format on stderr w/ the fields kv the
+// default logger & routes the logs of grpc-go into it. Call it before using
+// grpc.
+func Setup(level string, format string, kv ...interface{}) (*Logger, error) {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	l, err := New(os.Stderr, format, lvl)
+	if err != nil {
+		return nil, err
+	}
+
+	l = l.With(kv...)
+	SetDefault(l)
+	grpclog.SetLoggerV2(NewGrpcLogger(l.With("component", "grpc")))
+	return l, nil
+}
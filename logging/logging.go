@@ -0,0 +1,232 @@
+// Package logging is a levelled logger that writes one record per line as
+// logfmt or JSON. A record has a time, a level, a message & key/value
+// fields, the ones added w/ With first. Loggers derived w/ With share the
+// output & the level of the one they were derived from, so the level of a
+// whole process can be changed at runtime.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int32
+
+const (
+	DebugLevel Level = iota
+
+	InfoLevel
+
+	WarnLevel
+
+	ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return strconv.Itoa(int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("log level %q is not debug, info, warn or error", s)
+}
+
+const (
+	FormatLogfmt = "logfmt"
+
+	FormatJSON = "json"
+)
+
+// sink is the output & level shared by a logger & the ones derived from it.
+type sink struct {
+	mu sync.Mutex
+
+	w io.Writer
+
+	json bool
+
+	level int32
+}
+
+type Logger struct {
+	sink *sink
+
+	// key/value pairs added to every record
+	fields []interface{}
+}
+
+// New returns a logger writing records of level & above to w, format is
+// logfmt or json.
+func New(w io.Writer, format string, level Level) (*Logger, error) {
+	if format != FormatLogfmt && format != FormatJSON {
+		return nil, fmt.Errorf("log format %q is not logfmt or json", format)
+	}
+	return &Logger{sink: &sink{w: w, json: format == FormatJSON, level: int32(level)}}, nil
+}
+
+var std atomic.Value
+
+func init() {
+	l, _ := New(os.Stderr, FormatLogfmt, InfoLevel)
+	std.Store(l)
+}
+
+// Default is the logger of the process, logfmt at info on stderr until
+// SetDefault.
+func Default() *Logger {
+	return std.Load().(*Logger)
+}
+
+func SetDefault(l *Logger) {
+	std.Store(l)
+}
+
+// With returns a logger that adds the key/value pairs kv to every record.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{sink: l.sink, fields: fields}
+}
+
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&l.sink.level))
+}
+
+// SetLevel changes the level of l & of all loggers sharing its output.
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.sink.level, int32(level))
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.Log(DebugLevel, msg, kv...) }
+
+func (l *Logger) Info(msg string, kv ...interface{}) { l.Log(InfoLevel, msg, kv...) }
+
+func (l *Logger) Warn(msg string, kv ...interface{}) { l.Log(WarnLevel, msg, kv...) }
+
+func (l *Logger) Error(msg string, kv ...interface{}) { l.Log(ErrorLevel, msg, kv...) }
+
+func (l *Logger) Debugf(format string, args ...interface{}) { l.logf(DebugLevel, format, args) }
+
+func (l *Logger) Infof(format string, args ...interface{}) { l.logf(InfoLevel, format, args) }
+
+func (l *Logger) Warnf(format string, args ...interface{}) { l.logf(WarnLevel, format, args) }
+
+func (l *Logger) Errorf(format string, args ...interface{}) { l.logf(ErrorLevel, format, args) }
+
+// Fatalf logs at error level & exits.
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.logf(ErrorLevel, format, args)
+	os.Exit(1)
+}
+
+func (l *Logger) logf(level Level, format string, args []interface{}) {
+	if l.Enabled(level) {
+		l.Log(level, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+	}
+}
+
+// Log writes a record w/ the fields of l followed by kv, when level is
+// enabled.
+func (l *Logger) Log(level Level, msg string, kv ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := make([]interface{}, 0, 6+len(l.fields)+len(kv))
+	fields = append(fields, "time", time.Now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(missing)")
+	}
+
+	var b strings.Builder
+	if l.sink.json {
+		writeJSON(&b, fields)
+	} else {
+		writeLogfmt(&b, fields)
+	}
+	b.WriteByte('\n')
+
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	io.WriteString(l.sink.w, b.String())
+}
+
+func writeLogfmt(b *strings.Builder, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(fmt.Sprint(fields[i]))
+		b.WriteByte('=')
+
+		v := formatValue(fields[i+1])
+		if s, ok := v.(string); ok {
+			if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+				s = strconv.Quote(s)
+			}
+			b.WriteString(s)
+		} else {
+			fmt.Fprint(b, v)
+		}
+	}
+}
+
+func writeJSON(b *strings.Builder, fields []interface{}) {
+	b.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(fmt.Sprint(fields[i]))
+		v, err := json.Marshal(formatValue(fields[i+1]))
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(fields[i+1]))
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+}
+
+// formatValue returns numbers & bools as is & anything else as a string.
+func formatValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return "<nil>"
+	case string:
+		return v
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
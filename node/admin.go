@@ -0,0 +1,63 @@
+package node
+
+import (
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AdminServer exposes the runtime state of this server.
+type AdminServer struct {
+	api.UnimplementedAdminServer
+
+	limiter *rateLimiter
+
+	load *loadTracker
+
+	node *Node
+
+	log *logging.Logger
+}
+
+func (a *AdminServer) GetRateLimits(ctx context.Context, e *api.Empty) (*api.RateLimitsResponse, error) {
+	if a.limiter == nil {
+		return &api.RateLimitsResponse{}, nil
+	}
+	return a.limiter.State(), nil
+}
+
+func (a *AdminServer) GetLoad(ctx context.Context, e *api.Empty) (*api.LoadReport, error) {
+	return a.load.Report(), nil
+}
+
+func (a *AdminServer) GetLogLevel(ctx context.Context, e *api.Empty) (*api.LogLevel, error) {
+	return &api.LogLevel{Level: a.log.Level().String()}, nil
+}
+
+// SetLogLevel changes the level of the logger this server shares w/ the
+// rest of the process, grpc's included.
+func (a *AdminServer) SetLogLevel(ctx context.Context, req *api.LogLevel) (*api.LogLevel, error) {
+	level, err := logging.ParseLevel(req.Level)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	from := a.log.Level()
+	a.log.SetLevel(level)
+	a.log.Log(logging.WarnLevel, "log level changed", "from", from, "to", level)
+	return &api.LogLevel{Level: level.String()}, nil
+}
+
+func (a *AdminServer) ReloadConfig(ctx context.Context, e *api.Empty) (*api.ReloadConfigResponse, error) {
+	changes, err := a.node.ReloadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &api.ReloadConfigResponse{Changes: changes}, nil
+}
+
+func newAdminServer(limiter *rateLimiter, load *loadTracker, node *Node, log *logging.Logger) *AdminServer {
+	return &AdminServer{limiter: limiter, load: load, node: node, log: log}
+}
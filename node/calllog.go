@@ -0,0 +1,60 @@
+package node
+
+import (
+	"github.com/1xyz/grpc-playground/logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// callLogger logs every call once it is done w/ its method, peer, code &
+// duration & counts it in the metrics. Calls failing w/ a server side error
+// are logged at warn, the others at debug.
+type callLogger struct {
+	echoServer *EchoServer
+
+	metrics *callMetrics
+}
+
+func (c *callLogger) done(ctx context.Context, method string, start time.Time, err error) {
+	c.metrics.observe(method, status.Code(err), time.Since(start))
+
+	level := logging.DebugLevel
+	switch status.Code(err) {
+	case codes.Unknown, codes.Internal, codes.DataLoss, codes.Unimplemented:
+		level = logging.WarnLevel
+	}
+
+	if !c.echoServer.log.Enabled(level) {
+		return
+	}
+
+	addr := "unknown"
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr = p.Addr.String()
+	}
+	kv := []interface{}{"method", method, "peer", addr, "code", status.Code(err), "duration", time.Since(start)}
+	if err != nil {
+		kv = append(kv, "err", status.Convert(err).Message())
+	}
+	c.echoServer.logger().Log(level, "call", kv...)
+}
+
+func (c *callLogger) UnaryInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	c.done(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func (c *callLogger) StreamInterceptor(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	c.done(ss.Context(), info.FullMethod, start, err)
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package node
+
+import "time"
+
+// cpuTime returns false, the cpu time of the process is not known here.
+func cpuTime() (time.Duration, bool) {
+	return 0, false
+}
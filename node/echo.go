@@ -0,0 +1,288 @@
+package node
+
+import (
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/echoerr"
+	"github.com/1xyz/grpc-playground/logging"
+	"github.com/google/uuid"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// health overrides
+const (
+	healthServing = "serving"
+
+	healthNotServing = "not_serving"
+)
+
+type EchoServer struct {
+	api.UnimplementedEchoServer
+
+	id string
+
+	shutdownCh chan bool
+
+	mu sync.RWMutex
+
+	isLeader bool
+
+	// delay added to every Echo
+	latency time.Duration
+
+	// shift of this server's physical clock, to simulate skew
+	clockOffset time.Duration
+
+	// how long FailingEcho asks callers to wait, negative for no retry
+	failingPushback time.Duration
+
+	clock *hybridClock
+
+	history *historyStore
+
+	feed *tickFeed
+
+	// w/ the server_id
+	log *logging.Logger
+}
+
+// logger returns the logger of this server w/ its current role.
+func (es *EchoServer) logger() *logging.Logger {
+	role := "follower"
+	if es.leader() {
+		role = "leader"
+	}
+	return es.log.With("role", role)
+}
+
+// wallTime is the physical time of this server.
+func (es *EchoServer) wallTime() time.Time {
+	return time.Now().Add(es.clockOffset).UTC()
+}
+
+func (es *EchoServer) Echo(ctx context.Context, req *api.EchoRequest) (*api.EchoResponse, error) {
+	es.logger().Debug("echo", "client_id", req.ClientId)
+	hlc, err := es.clock.Update(req.Hlc)
+	if err != nil {
+		return nil, err
+	}
+	if es.latency > 0 {
+		select {
+		case <-time.After(es.latency):
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+
+	t := es.wallTime()
+	return &api.EchoResponse{
+		ServerId:   es.id,
+		ClientId:   req.ClientId,
+		Clock:      t.Unix(),
+		ClockNanos: t.UnixNano(),
+		Hlc:        hlc,
+	}, nil
+}
+
+func (es *EchoServer) StreamEcho(req *api.EchoRequest, stream api.Echo_StreamEchoServer) error {
+	if _, err := es.clock.Update(req.Hlc); err != nil {
+		return err
+	}
+	from := uint64(0)
+	if req.ResumeToken != "" {
+		seq, err := decodeResumeToken(req.ResumeToken, es.id)
+		if err != nil {
+			return err
+		}
+		from = seq + 1
+	}
+
+	replay, ch, err := es.feed.subscribe(from)
+	if err != nil {
+		return err
+	}
+	defer es.feed.unsubscribe(ch)
+
+	send := func(tk *tick) error {
+		return stream.Send(&api.EchoResponse{
+			ServerId:    es.id,
+			ClientId:    req.ClientId,
+			Clock:       tk.t.Unix(),
+			ClockNanos:  tk.t.UnixNano(),
+			Hlc:         tk.hlc,
+			Sequence:    tk.seq,
+			ResumeToken: encodeResumeToken(es.id, tk.seq),
+		})
+	}
+
+	if len(replay) > 0 {
+		es.logger().Info("replaying ticks", "client_id", req.ClientId, "count", len(replay), "from", from)
+	}
+	for _, tk := range replay {
+		if err := send(tk); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case tk, ok := <-ch:
+			if !ok {
+				return status.Errorf(codes.ResourceExhausted,
+					"stream fell more than %d ticks behind", es.feed.subBuffer)
+			}
+			if err := send(tk); err != nil {
+				return err
+			}
+
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+
+		case <-es.shutdownCh:
+			return nil
+		}
+	}
+}
+
+func (es *EchoServer) FailingEcho(ctx context.Context, req *api.EchoRequest) (*api.EchoResponse, error) {
+	es.logger().Debug("failing echo", "client_id", req.ClientId)
+	if _, err := es.clock.Update(req.Hlc); err != nil {
+		return nil, err
+	}
+	return nil, &echoerr.Error{
+		Code:       codes.Unavailable,
+		Message:    "I am just gonna fail",
+		Reason:     echoerr.ReasonFailingEcho,
+		Domain:     echoerr.Domain,
+		Metadata:   map[string]string{"server_id": es.id, "client_id": req.ClientId},
+		Retry:      es.failingPushback >= 0,
+		RetryDelay: es.failingPushback,
+		NoRetry:    es.failingPushback < 0,
+		Debug:      "FailingEcho fails every call, call Echo instead",
+	}
+}
+
+func (es *EchoServer) IsLeader(ctx context.Context, e *api.Empty) (*api.IsLeaderResponse, error) {
+	return &api.IsLeaderResponse{IsLeader: es.leader()}, nil
+}
+
+func (es *EchoServer) leader() bool {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.isLeader
+}
+
+func (es *EchoServer) setLeader(isLeader bool) {
+	es.mu.Lock()
+	changed := es.isLeader != isLeader
+	es.isLeader = isLeader
+	es.mu.Unlock()
+
+	if changed {
+		es.logger().Info("role changed", "is_leader", isLeader)
+	}
+}
+
+func (es *EchoServer) ListHistory(ctx context.Context, req *api.ListHistoryRequest) (*api.ListHistoryResponse, error) {
+	return es.history.List(req)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////////////
+
+type HealthCheckServer struct {
+	healthgrpc.UnimplementedHealthServer
+
+	echoServer *EchoServer
+
+	tickDuration time.Duration
+
+	// healthServing or healthNotServing forces the status reported, empty
+	// follows the leadership of the server
+	override atomic.Value
+}
+
+func (h *HealthCheckServer) Check(ctx context.Context,
+	req *healthgrpc.HealthCheckRequest) (*healthgrpc.HealthCheckResponse, error) {
+	s := healthgrpc.HealthCheckResponse_UNKNOWN
+	if h.echoServer == nil {
+		return &healthgrpc.HealthCheckResponse{Status: s}, nil
+	}
+	h.echoServer.log.Debug("health check", "service", req.Service)
+
+	if override, _ := h.override.Load().(string); override == healthServing {
+		s = healthgrpc.HealthCheckResponse_SERVING
+	} else if override == healthNotServing {
+		s = healthgrpc.HealthCheckResponse_NOT_SERVING
+	} else {
+		if h.echoServer.leader() {
+			s = healthgrpc.HealthCheckResponse_SERVING
+		} else {
+			s = healthgrpc.HealthCheckResponse_NOT_SERVING
+		}
+	}
+
+	return &healthgrpc.HealthCheckResponse{
+		Status: s,
+	}, nil
+}
+
+func (h *HealthCheckServer) Watch(req *healthgrpc.HealthCheckRequest, stream healthgrpc.Health_WatchServer) error {
+	ticker := time.NewTicker(h.tickDuration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case t := <-ticker.C:
+			resp, err := h.Check(stream.Context(), req)
+			if err != nil {
+				return err
+			}
+
+			h.echoServer.log.Debug("health watch", "service", req.Service, "status", resp.Status, "at", t)
+			stream.Send(resp)
+
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+
+		case <-h.echoServer.shutdownCh:
+			return nil
+		}
+	}
+}
+
+func newEchoServer(cfg Config, logger *logging.Logger) *EchoServer {
+	a := &EchoServer{
+		id:              uuid.New().String(),
+		shutdownCh:      make(chan bool),
+		isLeader:        cfg.Leader,
+		latency:         cfg.Latency,
+		clockOffset:     cfg.ClockOffset,
+		failingPushback: cfg.FailingEchoPushback,
+	}
+	a.log = logger.With("server_id", a.id)
+	a.clock = newHybridClock(func() int64 { return a.wallTime().UnixNano() }, cfg.MaxClockOffset, a.log.With("component", "hlc"))
+
+	a.logger().Info("new server", "is_leader", a.isLeader)
+	return a
+}
+
+// setOverride forces the status reported to healthServing or
+// healthNotServing, empty follows leadership again.
+func (h *HealthCheckServer) setOverride(override string) {
+	h.override.Store(override)
+}
+
+func newHealthCheckServer(server *EchoServer, tickDuration time.Duration) *HealthCheckServer {
+	h := &HealthCheckServer{
+		echoServer:   server,
+		tickDuration: tickDuration,
+	}
+
+	server.log.Debug("created health check server")
+	return h
+}
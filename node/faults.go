@@ -0,0 +1,147 @@
+package node
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/echoerr"
+	"github.com/1xyz/grpc-playground/logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fault struct {
+	code codes.Code
+
+	// fraction of the calls failed
+	probability float64
+
+	// set when callers are told how long to wait before retrying, a
+	// negative pushback tells them not to retry
+	hasPushback bool
+
+	pushback time.Duration
+}
+
+// parseFaults parses a spec like "Echo=UNAVAILABLE:0.1:2s,*=INTERNAL:0.01"
+// into faults keyed by method, each given as code:probability:pushback. The
+// probability is 1 when left out. The pushback, a duration or never, tells
+// callers how long to wait before retrying, when left out it is up to them.
+// Methods are named like in rate limits, * fails all methods w/o a fault of
+// their own. The controlServices never fail, so that faults can always be
+// removed through Admin & balancers still see the real health.
+func parseFaults(spec string) (map[string]fault, error) {
+	faults := make(map[string]fault)
+	if spec == "" {
+		return faults, nil
+	}
+
+	for _, e := range strings.Split(spec, ",") {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid fault %q, want method=code:probability:pushback", e)
+		}
+
+		cp := strings.SplitN(kv[1], ":", 3)
+		var code codes.Code
+		if err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(cp[0])))); err != nil || code == codes.OK {
+			return nil, fmt.Errorf("invalid code in %q", e)
+		}
+
+		flt := fault{code: code, probability: 1}
+		if len(cp) >= 2 {
+			var err error
+			if flt.probability, err = strconv.ParseFloat(cp[1], 64); err != nil || flt.probability <= 0 || flt.probability > 1 {
+				return nil, fmt.Errorf("invalid probability in %q, want (0, 1]", e)
+			}
+		}
+		if len(cp) == 3 {
+			flt.hasPushback, flt.pushback = true, -1
+			if cp[2] != "never" {
+				var err error
+				if flt.pushback, err = time.ParseDuration(cp[2]); err != nil || flt.pushback < 0 {
+					return nil, fmt.Errorf("invalid pushback in %q, want a duration or never", e)
+				}
+			}
+		}
+		faults[kv[0]] = flt
+	}
+	return faults, nil
+}
+
+// faultInjector fails a fraction of the calls of some methods w/ a given
+// code, e.g. to see how clients cope w/ an unhealthy server. Its faults can
+// be replaced while it runs.
+type faultInjector struct {
+	mu sync.Mutex
+
+	faults map[string]fault
+
+	rand *rand.Rand
+
+	log *logging.Logger
+}
+
+func newFaultInjector(faults map[string]fault, log *logging.Logger) *faultInjector {
+	return &faultInjector{faults: faults, rand: rand.New(rand.NewSource(time.Now().UnixNano())), log: log}
+}
+
+func (f *faultInjector) set(faults map[string]fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = faults
+}
+
+// inject returns the error fullMethod should fail w/, if any.
+func (f *faultInjector) inject(fullMethod string) error {
+	if isControlMethod(fullMethod) {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	flt, ok := f.faults[fullMethod]
+	if !ok {
+		flt, ok = f.faults[fullMethod[strings.LastIndex(fullMethod, "/")+1:]]
+	}
+	if !ok {
+		flt, ok = f.faults[globalMethod]
+	}
+	if !ok || f.rand.Float64() >= flt.probability {
+		return nil
+	}
+
+	f.log.Debug("injected fault", "method", fullMethod, "code", flt.code)
+	return &echoerr.Error{
+		Code:       flt.code,
+		Message:    fmt.Sprintf("injected fault on %v", fullMethod),
+		Reason:     echoerr.ReasonInjectedFault,
+		Domain:     echoerr.Domain,
+		Metadata:   map[string]string{"method": fullMethod},
+		Retry:      flt.hasPushback && flt.pushback >= 0,
+		RetryDelay: flt.pushback,
+		NoRetry:    flt.hasPushback && flt.pushback < 0,
+		Debug:      fmt.Sprintf("the faults setting fails %v of the calls w/ %v", flt.probability, flt.code),
+	}
+}
+
+func (f *faultInjector) UnaryInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := f.inject(info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (f *faultInjector) StreamInterceptor(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := f.inject(info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
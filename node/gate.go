@@ -0,0 +1,110 @@
+package node
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"sync"
+)
+
+// gate holds calls while a node is paused. Connections stay open & calls
+// are accepted, but nothing is handled or sent until the gate opens, which
+// is how a stopped (SIGSTOP) process looks to its clients.
+type gate struct {
+	mu sync.Mutex
+
+	// closed while the gate is open
+	open chan struct{}
+}
+
+func newGate() *gate {
+	g := &gate{open: make(chan struct{})}
+	close(g.open)
+	return g
+}
+
+func (g *gate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-g.open:
+		g.open = make(chan struct{})
+	default:
+	}
+}
+
+func (g *gate) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-g.open:
+	default:
+		close(g.open)
+	}
+}
+
+func (g *gate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-g.open:
+		return false
+	default:
+		return true
+	}
+}
+
+// wait returns once the gate is open or ctx is done.
+func (g *gate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	open := g.open
+	g.mu.Unlock()
+
+	select {
+	case <-open:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func (g *gate) UnaryInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := g.wait(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := handler(ctx, req)
+	if werr := g.wait(ctx); werr != nil {
+		return nil, werr
+	}
+	return resp, err
+}
+
+func (g *gate) StreamInterceptor(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := g.wait(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, &gatedStream{ServerStream: ss, g: g})
+}
+
+// gatedStream holds the messages of a stream while the gate is closed.
+type gatedStream struct {
+	grpc.ServerStream
+
+	g *gate
+}
+
+func (s *gatedStream) SendMsg(m interface{}) error {
+	if err := s.g.wait(s.Context()); err != nil {
+		return err
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *gatedStream) RecvMsg(m interface{}) error {
+	if err := s.g.wait(s.Context()); err != nil {
+		return err
+	}
+	return s.ServerStream.RecvMsg(m)
+}
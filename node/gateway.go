@@ -0,0 +1,165 @@
+package node
+
+import (
+	"bytes"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/logging"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"strings"
+)
+
+// newGateway returns a http handler that transcodes the REST routes of
+// api.Echo, api.Admin & api.PubSub (see the google.api.http options) to the gRPC
+// server at grpcAddr. Besides the generated routes it serves
+//
+//	GET /v1/health         health check, ?service=<name> is optional
+//	GET /v1/openapi.json   the generated OpenAPI document
+//
+// StreamEcho & Subscribe are returned as newline delimited JSON, or as server-sent
+// events when the request accepts text/event-stream.
+func newGateway(ctx context.Context, grpcAddr string, openapiPath string, log *logging.Logger) (http.Handler, error) {
+	if strings.HasPrefix(grpcAddr, ":") {
+		grpcAddr = "localhost" + grpcAddr
+	}
+
+	conn, err := grpc.DialContext(ctx, grpcAddr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	gw := runtime.NewServeMux(runtime.WithMarshalerOption(runtime.MIMEWildcard,
+		&runtime.JSONPb{OrigName: true, EmitDefaults: true}))
+	if err := api.RegisterEchoHandler(ctx, gw, conn); err != nil {
+		return nil, err
+	}
+	if err := api.RegisterAdminHandler(ctx, gw, conn); err != nil {
+		return nil, err
+	}
+	if err := api.RegisterPubSubHandler(ctx, gw, conn); err != nil {
+		return nil, err
+	}
+
+	health := healthgrpc.NewHealthClient(conn)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/health", func(w http.ResponseWriter, r *http.Request) {
+		resp, err := health.Check(r.Context(), &healthgrpc.HealthCheckRequest{
+			Service: r.URL.Query().Get("service"),
+		})
+		if err != nil {
+			runtime.HTTPError(r.Context(), gw, &runtime.JSONPb{}, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if resp.Status != healthgrpc.HealthCheckResponse_SERVING {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		m := &runtime.JSONPb{OrigName: true, EmitDefaults: true}
+		if err := m.NewEncoder(w).Encode(resp); err != nil {
+			log.Error("encode health response", "err", err)
+		}
+	})
+	mux.HandleFunc("/v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, openapiPath)
+	})
+	mux.Handle("/", withEventStream(gw))
+	return mux, nil
+}
+
+// withEventStream re-frames the newline delimited messages of a streaming
+// response as server-sent events for clients that ask for them.
+func withEventStream(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		r.Header.Set("Accept", "application/json")
+		sw := &sseWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		sw.flushLine()
+	})
+}
+
+type sseWriter struct {
+	http.ResponseWriter
+
+	buf bytes.Buffer
+
+	wroteHeader bool
+}
+
+// setHeaders replaces the JSON content type the gateway sets w/ the event
+// stream headers. The gateway only calls WriteHeader on errors, a streamed
+// call goes straight to Write, so this runs before anything is written.
+func (s *sseWriter) setHeaders() {
+	if s.wroteHeader {
+		return
+	}
+	s.wroteHeader = true
+	s.Header().Set("Content-Type", "text/event-stream")
+	s.Header().Set("Cache-Control", "no-cache")
+}
+
+func (s *sseWriter) WriteHeader(code int) {
+	s.setHeaders()
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *sseWriter) Write(b []byte) (int, error) {
+	s.setHeaders()
+	s.buf.Write(b)
+	for {
+		i := bytes.IndexByte(s.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+
+		line := s.buf.Next(i + 1)
+		if err := s.writeEvent(bytes.TrimSpace(line)); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (s *sseWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// flushLine emits whatever is left in the buffer, e.g. a unary response
+// that is not newline terminated.
+func (s *sseWriter) flushLine() {
+	s.setHeaders()
+	if line := bytes.TrimSpace(s.buf.Bytes()); len(line) > 0 {
+		s.writeEvent(line)
+		s.buf.Reset()
+	}
+	s.Flush()
+}
+
+func (s *sseWriter) writeEvent(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if _, err := s.ResponseWriter.Write([]byte("data: ")); err != nil {
+		return err
+	}
+	if _, err := s.ResponseWriter.Write(data); err != nil {
+		return err
+	}
+	_, err := s.ResponseWriter.Write([]byte("\n\n"))
+	s.Flush()
+	return err
+}
@@ -0,0 +1,100 @@
+package node
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/1xyz/grpc-playground/logging"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startNode serves a leader w/ fast ticks on a loopback port, w/ the config
+// changed by configure if not nil.
+func startNode(t *testing.T, configure func(cfg *Config)) (*Node, string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: err = %v", err)
+	}
+
+	log, _ := logging.New(ioutil.Discard, "logfmt", logging.ErrorLevel)
+	cfg := DefaultConfig()
+	cfg.Address = lis.Addr().String()
+	cfg.Leader = true
+	cfg.TickInterval = 10 * time.Millisecond
+	cfg.Logger = log
+	if configure != nil {
+		configure(&cfg)
+	}
+	n, err := New(cfg)
+	if err != nil {
+		t.Fatalf("new node: err = %v", err)
+	}
+	go n.Serve(lis)
+	return n, cfg.Address
+}
+
+func TestGatewayEventStream(t *testing.T) {
+	n, addr := startNode(t, nil)
+	defer n.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	gw, err := newGateway(ctx, addr, "", n.log)
+	if err != nil {
+		t.Fatalf("gateway: err = %v", err)
+	}
+	hs := httptest.NewServer(gw)
+	defer hs.Close()
+
+	req, _ := http.NewRequest("GET", hs.URL+"/v1/echo/sse/stream", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("get: err = %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %v, want 200", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "no-cache" {
+		t.Fatalf("Cache-Control = %q, want no-cache", cc)
+	}
+
+	// every event is a data: line holding one message, then a blank line
+	r := bufio.NewReader(resp.Body)
+	for i := 0; i < 3; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("event %d: err = %v", i, err)
+		}
+		if !strings.HasPrefix(line, "data: ") {
+			t.Fatalf("event %d = %q, want a data: line", i, line)
+		}
+
+		var msg struct {
+			Result struct {
+				ServerID string `json:"server_id"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg); err != nil {
+			t.Fatalf("event %d = %q: err = %v", i, line, err)
+		}
+		if msg.Result.ServerID != n.ID() {
+			t.Fatalf("event %d from server id = %q, want %v", i, msg.Result.ServerID, n.ID())
+		}
+
+		if blank, err := r.ReadString('\n'); err != nil || blank != "\n" {
+			t.Fatalf("event %d ends w/ %q, err = %v, want a blank line", i, blank, err)
+		}
+	}
+}
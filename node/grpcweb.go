@@ -0,0 +1,48 @@
+package node
+
+import (
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"net/http"
+	"strings"
+)
+
+// newGrpcWebHandler wraps the gRPC server so browsers can call every
+// registered method, server streams included, using the grpc-web protocol in
+// binary (application/grpc-web) or text (application/grpc-web-text) mode.
+//
+// Native gRPC requests (HTTP/2 w/ prior knowledge) are handed to the server
+// as well, which lets grpc-web share the port of the native listener.
+func newGrpcWebHandler(s *grpc.Server, origins []string) http.Handler {
+	ws := grpcweb.WrapServer(s,
+		grpcweb.WithOriginFunc(allowOrigin(origins)),
+		grpcweb.WithAllowedRequestHeaders([]string{"*"}))
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case ws.IsGrpcWebRequest(r), ws.IsAcceptableGrpcCorsRequest(r):
+			ws.ServeHTTP(w, r)
+		case r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc"):
+			s.ServeHTTP(w, r)
+		default:
+			http.Error(w, "not a grpc-web request", http.StatusUnsupportedMediaType)
+		}
+	})
+
+	return h2c.NewHandler(h, &http2.Server{})
+}
+
+// allowOrigin matches a CORS origin against the allowed list, where "*"
+// allows any origin.
+func allowOrigin(origins []string) func(string) bool {
+	return func(origin string) bool {
+		for _, o := range origins {
+			if o == "*" || strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,316 @@
+package node
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyStore keeps the last size echo records in memory. With a file it
+// also appends every record to it as a line of JSON & loads the tail of it
+// on start, so that the history survives restarts. The file is rewritten
+// w/ just the records in memory once it holds twice as many.
+type historyStore struct {
+	mu sync.Mutex
+
+	size int
+
+	// page size when none is asked for & the largest one served
+	pageSize int
+
+	maxPageSize int
+
+	// ordered by id
+	records []*api.EchoRecord
+
+	nextId uint64
+
+	path string
+
+	file *os.File
+
+	// records in the file
+	lines int
+
+	log *logging.Logger
+}
+
+func newHistoryStore(size int, pageSize int, maxPageSize int, path string, log *logging.Logger) (*historyStore, error) {
+	h := &historyStore{size: size, pageSize: pageSize, maxPageSize: maxPageSize, nextId: 1, path: path, log: log}
+	if path == "" {
+		return h, nil
+	}
+
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	h.file = f
+	h.log.Info("loaded history", "records", len(h.records), "path", path)
+	return h, nil
+}
+
+func (h *historyStore) load() error {
+	f, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		r := &api.EchoRecord{}
+		if err := protojson.Unmarshal(sc.Bytes(), r); err != nil {
+			// a torn last line from a crash
+			h.log.Warn("skipping history line", "line", h.lines+1, "path", h.path, "err", err)
+			continue
+		}
+		h.lines++
+		h.append(r)
+	}
+	return sc.Err()
+}
+
+func (h *historyStore) append(r *api.EchoRecord) {
+	h.records = append(h.records, r)
+	if len(h.records) > h.size {
+		h.records = h.records[len(h.records)-h.size:]
+	}
+	if r.Id >= h.nextId {
+		h.nextId = r.Id + 1
+	}
+}
+
+// Add assigns the next id to r & stores it.
+func (h *historyStore) Add(r *api.EchoRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r.Id = h.nextId
+	h.append(r)
+	if h.file == nil {
+		return
+	}
+
+	b, err := protojson.Marshal(r)
+	if err != nil {
+		h.log.Error("marshal history record", "err", err)
+		return
+	}
+	if _, err := h.file.Write(append(b, '\n')); err != nil {
+		h.log.Error("write history record", "path", h.path, "err", err)
+		return
+	}
+	if h.lines++; h.lines >= 2*h.size {
+		if err := h.compact(); err != nil {
+			h.log.Error("compact history", "path", h.path, "err", err)
+		}
+	}
+}
+
+// compact replaces the file w/ one holding just the records in memory.
+func (h *historyStore) compact() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(h.path), filepath.Base(h.path)+".*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for _, r := range h.records {
+		b, err := protojson.Marshal(r)
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), h.path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	h.file.Close()
+	h.file = f
+	h.lines = len(h.records)
+	return nil
+}
+
+// Close closes the file of the store, if any.
+func (h *historyStore) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	err := h.file.Close()
+	h.file = nil
+	return err
+}
+
+// filterHash ties a page token to the filter of the request it came from.
+func filterHash(req *api.ListHistoryRequest) uint32 {
+	f := fnv.New32a()
+	fmt.Fprintf(f, "%s/%d/%d", req.ClientId, req.StartNanos, req.EndNanos)
+	return f.Sum32()
+}
+
+func encodePageToken(id uint64, req *api.ListHistoryRequest) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", id, filterHash(req))))
+}
+
+// decodePageToken returns the id a page starts at.
+func decodePageToken(token string, req *api.ListHistoryRequest) (uint64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, fmt.Errorf("malformed page_token")
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("malformed page_token")
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed page_token")
+	}
+	if parts[1] != strconv.FormatUint(uint64(filterHash(req)), 10) {
+		return 0, fmt.Errorf("page_token is for a different filter")
+	}
+	return id, nil
+}
+
+// List returns a page of the records matching req.
+func (h *historyStore) List(req *api.ListHistoryRequest) (*api.ListHistoryResponse, error) {
+	size := int(req.PageSize)
+	switch {
+	case size < 0:
+		return nil, status.Errorf(codes.InvalidArgument, "page_size = %d must not be negative", size)
+	case size == 0:
+		size = h.pageSize
+	case size > h.maxPageSize:
+		size = h.maxPageSize
+	}
+
+	from := uint64(0)
+	if req.PageToken != "" {
+		var err error
+		if from, err = decodePageToken(req.PageToken, req); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	resp := &api.ListHistoryResponse{}
+	i := sort.Search(len(h.records), func(i int) bool { return h.records[i].Id >= from })
+	for ; i < len(h.records); i++ {
+		r := h.records[i]
+		if req.ClientId != "" && r.ClientId != req.ClientId ||
+			req.StartNanos != 0 && r.TimeNanos < req.StartNanos ||
+			req.EndNanos != 0 && r.TimeNanos >= req.EndNanos {
+			continue
+		}
+		if len(resp.Records) == size {
+			resp.NextPageToken = encodePageToken(r.Id, req)
+			break
+		}
+		resp.Records = append(resp.Records, r)
+	}
+	return resp, nil
+}
+
+// recorded tells if calls of fullMethod go into the history.
+func recorded(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/api.Echo/") && fullMethod != "/api.Echo/ListHistory" &&
+		fullMethod != "/api.Echo/IsLeader"
+}
+
+func (h *historyStore) record(ctx context.Context, method string, clientId string, start time.Time, err error) {
+	r := &api.EchoRecord{
+		ClientId:      clientId,
+		TimeNanos:     start.UnixNano(),
+		Method:        method,
+		Code:          status.Code(err).String(),
+		DurationNanos: int64(time.Since(start)),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		r.Peer = p.Addr.String()
+	}
+	h.Add(r)
+}
+
+func (h *historyStore) UnaryInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !recorded(info.FullMethod) {
+		return handler(ctx, req)
+	}
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	clientId := ""
+	if r, ok := req.(interface{ GetClientId() string }); ok {
+		clientId = r.GetClientId()
+	}
+	h.record(ctx, info.FullMethod, clientId, start, err)
+	return resp, err
+}
+
+func (h *historyStore) StreamInterceptor(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !recorded(info.FullMethod) {
+		return handler(srv, ss)
+	}
+
+	start := time.Now()
+	rs := &recordingStream{ServerStream: ss}
+	err := handler(srv, rs)
+	h.record(ss.Context(), info.FullMethod, rs.clientId, start, err)
+	return err
+}
+
+// recordingStream remembers the client_id of the first request message.
+type recordingStream struct {
+	grpc.ServerStream
+
+	clientId string
+}
+
+func (s *recordingStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if r, ok := m.(interface{ GetClientId() string }); ok && err == nil && s.clientId == "" {
+		s.clientId = r.GetClientId()
+	}
+	return err
+}
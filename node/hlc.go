@@ -0,0 +1,109 @@
+package node
+
+import (
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math"
+	"sync"
+	"time"
+)
+
+// hybridClock is a hybrid logical clock (Kulkarni et al.). It stays close to
+// physical time, yet never goes backwards & orders every event after the
+// events it has heard of, even when physical clocks of servers disagree.
+type hybridClock struct {
+	mu sync.Mutex
+
+	wall int64
+
+	logical uint32
+
+	// physical time in unix nanoseconds
+	physical func() int64
+
+	// remote clocks further ahead of our physical time than this are
+	// rejected
+	maxOffset time.Duration
+
+	log *logging.Logger
+}
+
+func newHybridClock(physical func() int64, maxOffset time.Duration, log *logging.Logger) *hybridClock {
+	return &hybridClock{physical: physical, maxOffset: maxOffset, log: log}
+}
+
+// Now advances the clock for a local event & returns its value.
+func (c *hybridClock) Now() *api.HybridTimestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if pt := c.physical(); pt > c.wall {
+		c.wall, c.logical = pt, 0
+	} else {
+		c.wall, c.logical = next(c.wall, c.logical)
+	}
+	return c.timestamp()
+}
+
+// Update merges a timestamp received from elsewhere into the clock & returns
+// the value of the receive event, which is after both. A nil remote is the
+// same as Now. A remote further ahead of physical time than maxOffset is
+// rejected w/ FailedPrecondition & leaves the clock as it was, so that one
+// bad clock cannot drag all the others into the future.
+func (c *hybridClock) Update(remote *api.HybridTimestamp) (*api.HybridTimestamp, error) {
+	if remote == nil {
+		return c.Now(), nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pt := c.physical()
+	if offset := time.Duration(remote.WallNanos - pt); offset > c.maxOffset {
+		c.log.Warn("remote clock rejected, ahead of physical time", "offset", offset)
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"hlc is %v ahead of the server clock, more than max_clock_offset = %v", offset, c.maxOffset)
+	}
+
+	wall := max64(c.wall, max64(remote.WallNanos, pt))
+	switch {
+	case wall == c.wall && wall == remote.WallNanos:
+		c.wall, c.logical = next(wall, max32(c.logical, remote.Logical))
+	case wall == c.wall:
+		c.wall, c.logical = next(wall, c.logical)
+	case wall == remote.WallNanos:
+		c.wall, c.logical = next(wall, remote.Logical)
+	default:
+		c.wall, c.logical = wall, 0
+	}
+	return c.timestamp(), nil
+}
+
+// next returns the timestamp right after wall & logical. Once logical runs
+// out the wall time moves on by a nanosecond instead of wrapping to 0.
+func next(wall int64, logical uint32) (int64, uint32) {
+	if logical == math.MaxUint32 {
+		return wall + 1, 0
+	}
+	return wall, logical + 1
+}
+
+func (c *hybridClock) timestamp() *api.HybridTimestamp {
+	return &api.HybridTimestamp{WallNanos: c.wall, Logical: c.logical}
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func max32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
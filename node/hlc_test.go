@@ -0,0 +1,137 @@
+package node
+
+import (
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+const testMaxOffset = 100
+
+// newTestClock returns a clock at wall & logical whose physical time is *pt.
+func newTestClock(pt *int64, wall int64, logical uint32) *hybridClock {
+	log, _ := logging.New(ioutil.Discard, "logfmt", logging.ErrorLevel)
+	c := newHybridClock(func() int64 { return *pt }, testMaxOffset*time.Nanosecond, log)
+	c.wall, c.logical = wall, logical
+	return c
+}
+
+func hlc(wall int64, logical uint32) *api.HybridTimestamp {
+	return &api.HybridTimestamp{WallNanos: wall, Logical: logical}
+}
+
+func TestHybridClockNow(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+
+		wall int64
+
+		logical uint32
+
+		pt int64
+
+		want *api.HybridTimestamp
+	}{
+		{"physical ahead", 100, 5, 200, hlc(200, 0)},
+		{"physical equal", 100, 5, 100, hlc(100, 6)},
+		{"physical behind", 100, 5, 50, hlc(100, 6)},
+		{"logical overflow", 100, math.MaxUint32, 50, hlc(101, 0)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pt := tc.pt
+			c := newTestClock(&pt, tc.wall, tc.logical)
+			if got := c.Now(); api.CompareHLC(got, tc.want) != 0 {
+				t.Fatalf("Now() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHybridClockUpdate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+
+		wall int64
+
+		logical uint32
+
+		pt int64
+
+		remote *api.HybridTimestamp
+
+		// nil when the remote is rejected
+		want *api.HybridTimestamp
+	}{
+		{"nil remote", 100, 5, 200, nil, hlc(200, 0)},
+		{"physical ahead of both", 100, 5, 200, hlc(150, 9), hlc(200, 0)},
+		{"local ahead of both", 300, 5, 200, hlc(150, 9), hlc(300, 6)},
+		{"remote ahead of both", 100, 5, 200, hlc(250, 9), hlc(250, 10)},
+		{"local & remote equal, local logical larger", 250, 12, 200, hlc(250, 9), hlc(250, 13)},
+		{"local & remote equal, remote logical larger", 250, 5, 200, hlc(250, 9), hlc(250, 10)},
+		{"remote at max offset", 100, 5, 200, hlc(200+testMaxOffset, 0), hlc(200+testMaxOffset, 1)},
+		{"remote beyond max offset", 100, 5, 200, hlc(201+testMaxOffset, 0), nil},
+		{"remote logical overflow", 100, 5, 200, hlc(250, math.MaxUint32), hlc(251, 0)},
+		{"local logical overflow", 300, math.MaxUint32, 200, hlc(150, 9), hlc(301, 0)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pt := tc.pt
+			c := newTestClock(&pt, tc.wall, tc.logical)
+			got, err := c.Update(tc.remote)
+			if tc.want == nil {
+				if status.Code(err) != codes.FailedPrecondition {
+					t.Fatalf("Update(%v) err = %v, want FailedPrecondition", tc.remote, err)
+				}
+				if c.wall != tc.wall || c.logical != tc.logical {
+					t.Fatalf("clock = %v, %v after a rejected update, want %v, %v", c.wall, c.logical, tc.wall, tc.logical)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Update(%v) err = %v", tc.remote, err)
+			}
+			if api.CompareHLC(got, tc.want) != 0 {
+				t.Fatalf("Update(%v) = %v, want %v", tc.remote, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestHybridClockMonotonic mixes local & receive events w/ a physical clock
+// that jumps back & forth: every event is after the one before & after the
+// timestamp it received.
+func TestHybridClockMonotonic(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	pt := int64(1000)
+	c := newTestClock(&pt, 0, 0)
+
+	var last *api.HybridTimestamp
+	for i := 0; i < 10000; i++ {
+		pt += rnd.Int63n(21) - 10
+
+		var got *api.HybridTimestamp
+		var remote *api.HybridTimestamp
+		if rnd.Intn(2) == 0 {
+			got = c.Now()
+		} else {
+			remote = hlc(pt+rnd.Int63n(2*testMaxOffset)-testMaxOffset, uint32(rnd.Intn(3)))
+			var err error
+			if got, err = c.Update(remote); err != nil {
+				t.Fatalf("event %d: Update(%v) err = %v", i, remote, err)
+			}
+		}
+
+		if last != nil && api.CompareHLC(got, last) <= 0 {
+			t.Fatalf("event %d = %v, not after %v", i, got, last)
+		}
+		if remote != nil && api.CompareHLC(got, remote) <= 0 {
+			t.Fatalf("event %d = %v, not after the remote %v", i, got, remote)
+		}
+		last = got
+	}
+}
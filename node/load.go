@@ -0,0 +1,155 @@
+package node
+
+import (
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/logging"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"math"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// loadTracker measures the utilisation of this server & reports it to
+// clients in the trailer of every call, so that they can balance on it. The
+// controlServices are left out, they never wait for a slot.
+type loadTracker struct {
+	id string
+
+	inFlight int64
+
+	streams int64
+
+	queued int64
+
+	// slots for unary calls, nil when unbounded
+	slots chan struct{}
+
+	// math.Float64bits of the cpu utilization
+	cpu uint64
+
+	log *logging.Logger
+}
+
+func newLoadTracker(id string, maxConcurrent int, log *logging.Logger) *loadTracker {
+	l := &loadTracker{id: id, log: log}
+	if maxConcurrent > 0 {
+		l.slots = make(chan struct{}, maxConcurrent)
+	}
+	return l
+}
+
+// Report returns the current load of this server.
+func (l *loadTracker) Report() *api.LoadReport {
+	return &api.LoadReport{
+		ServerId:       l.id,
+		InFlight:       atomic.LoadInt64(&l.inFlight),
+		CpuUtilization: math.Float64frombits(atomic.LoadUint64(&l.cpu)),
+		ActiveStreams:  atomic.LoadInt64(&l.streams),
+		QueueDepth:     atomic.LoadInt64(&l.queued),
+	}
+}
+
+func (l *loadTracker) trailer() metadata.MD {
+	b, err := proto.Marshal(l.Report())
+	if err != nil {
+		l.log.Error("marshal load report", "err", err)
+		return nil
+	}
+	return metadata.Pairs(api.LoadReportTrailer, string(b))
+}
+
+// acquire waits for a free slot, the call is queued meanwhile.
+func (l *loadTracker) acquire(ctx context.Context) error {
+	if l.slots == nil {
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	atomic.AddInt64(&l.queued, 1)
+	defer atomic.AddInt64(&l.queued, -1)
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func (l *loadTracker) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// runCPUSampler updates the cpu utilization every interval from the cpu
+// time used by the process. It leaves it at 0 where that time is unknown.
+func (l *loadTracker) runCPUSampler(interval time.Duration, shutdownCh chan bool) {
+	lastCPU, ok := cpuTime()
+	if !ok {
+		l.log.Warn("cpu time unavailable, reporting a cpu utilization of 0")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastWall := time.Now()
+	for {
+		select {
+		case t := <-ticker.C:
+			c, ok := cpuTime()
+			if !ok {
+				continue
+			}
+			u := float64(c-lastCPU) / float64(t.Sub(lastWall)) / float64(runtime.NumCPU())
+			atomic.StoreUint64(&l.cpu, math.Float64bits(u))
+			lastWall, lastCPU = t, c
+
+		case <-shutdownCh:
+			return
+		}
+	}
+}
+
+func (l *loadTracker) UnaryInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if isControlMethod(info.FullMethod) {
+		return handler(ctx, req)
+	}
+
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+
+	atomic.AddInt64(&l.inFlight, 1)
+	defer atomic.AddInt64(&l.inFlight, -1)
+
+	resp, err := handler(ctx, req)
+	grpc.SetTrailer(ctx, l.trailer())
+	return resp, err
+}
+
+func (l *loadTracker) StreamInterceptor(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if isControlMethod(info.FullMethod) {
+		return handler(srv, ss)
+	}
+
+	atomic.AddInt64(&l.streams, 1)
+	defer atomic.AddInt64(&l.streams, -1)
+
+	err := handler(srv, ss)
+	ss.SetTrailer(l.trailer())
+	return err
+}
@@ -0,0 +1,498 @@
+// Package node runs an echo server w/ all of its services, so that it can be
+// started by the server command or several times in one process.
+package node
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/endpoint"
+	"github.com/1xyz/grpc-playground/logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/channelz/service"
+	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Config of a node, see the server command for what each field does. The
+// json names are the settings of the server's config file. Reload can
+// change tick_interval, faults, rate_limit, health_override & log_level
+// while the node runs, the others need a restart.
+type Config struct {
+	Address string `json:"address"`
+
+	// more endpoints served alongside Address, see the endpoint package
+	Listen []string `json:"listen"`
+
+	Leader bool `json:"leader"`
+
+	Reflection bool `json:"reflection"`
+
+	// gateway address, none when empty
+	HTTP string `json:"http"`
+
+	OpenAPI string `json:"openapi"`
+
+	// grpc-web address, none when empty
+	GrpcWeb string `json:"grpc_web"`
+
+	CORSOrigins []string `json:"cors_origins"`
+
+	// rate limits as method=rate:burst,..., none when empty
+	RateLimit string `json:"rate_limit"`
+
+	// idle rate limit buckets that are full again are dropped after this long
+	RateLimitIdleTimeout time.Duration `json:"rate_limit_idle_timeout"`
+
+	// calls failed on purpose as method=code:probability:pushback,..., none
+	// when empty
+	Faults string `json:"faults"`
+
+	// how long FailingEcho asks callers to wait before retrying, negative
+	// asks them not to retry
+	FailingEchoPushback time.Duration `json:"failing_echo_pushback"`
+
+	MaxConcurrent int `json:"max_concurrent"`
+
+	// period of the cpu utilization samples of the load reports
+	LoadSampleInterval time.Duration `json:"load_sample_interval"`
+
+	Latency time.Duration `json:"latency"`
+
+	ClockOffset time.Duration `json:"clock_offset"`
+
+	// hlcs of requests further ahead of our physical time than this are
+	// rejected w/ FailedPrecondition
+	MaxClockOffset time.Duration `json:"max_clock_offset"`
+
+	HistorySize int `json:"history_size"`
+
+	HistoryFile string `json:"history_file"`
+
+	// ListHistory page size when none is asked for & the largest one served
+	HistoryPageSize int `json:"history_page_size"`
+
+	HistoryMaxPageSize int `json:"history_max_page_size"`
+
+	Peers []string `json:"peers"`
+
+	// timeout of asking a peer whether it is the leader
+	PeerTimeout time.Duration `json:"peer_timeout"`
+
+	TopicRetention int `json:"topic_retention"`
+
+	// topics w/ subscribers or retained messages at once, new ones are
+	// rejected beyond that
+	MaxTopics int `json:"max_topics"`
+
+	StreamBuffer int `json:"stream_buffer"`
+
+	// ticks or messages buffered per stream or subscriber before it is
+	// dropped as too slow
+	SubscriberBuffer int `json:"subscriber_buffer"`
+
+	// period of stream ticks
+	TickInterval time.Duration `json:"tick_interval"`
+
+	// period of the health updates sent to watchers
+	HealthInterval time.Duration `json:"health_interval"`
+
+	// serving or not_serving forces the health reported, empty follows
+	// leadership
+	HealthOverride string `json:"health_override"`
+
+	// level of Logger, set on Reload, left as is when empty
+	LogLevel string `json:"log_level"`
+
+	// run after the built-in interceptors, e.g. to inject faults in tests
+	UnaryInterceptors []grpc.UnaryServerInterceptor `json:"-"`
+
+	StreamInterceptors []grpc.StreamServerInterceptor `json:"-"`
+
+	// used to dial the peers, on top of insecure
+	DialOptions []grpc.DialOption `json:"-"`
+
+	// logging.Default() when nil
+	Logger *logging.Logger `json:"-"`
+
+	// reads the config anew for ReloadConfig, nil when it cannot be
+	Loader func() (Config, error) `json:"-"`
+}
+
+// DefaultConfig returns the config of a server started w/o flags.
+func DefaultConfig() Config {
+	return Config{
+		Address:              ":11000",
+		OpenAPI:              "api/api.swagger.json",
+		CORSOrigins:          []string{"*"},
+		RateLimitIdleTimeout: time.Minute,
+		FailingEchoPushback:  time.Second,
+		LoadSampleInterval:   time.Second,
+		MaxClockOffset:       500 * time.Millisecond,
+		HistorySize:          10000,
+		HistoryPageSize:      100,
+		HistoryMaxPageSize:   1000,
+		PeerTimeout:          time.Second,
+		TopicRetention:       1000,
+		MaxTopics:            1000,
+		StreamBuffer:         60,
+		SubscriberBuffer:     256,
+		TickInterval:         time.Second,
+		HealthInterval:       time.Second,
+	}
+}
+
+// Validate reports the first setting of c that is out of range.
+func (c Config) Validate() error {
+	for _, d := range []struct {
+		name string
+
+		v time.Duration
+	}{
+		{"rate_limit_idle_timeout", c.RateLimitIdleTimeout},
+		{"load_sample_interval", c.LoadSampleInterval},
+		{"max_clock_offset", c.MaxClockOffset},
+		{"peer_timeout", c.PeerTimeout},
+		{"tick_interval", c.TickInterval},
+		{"health_interval", c.HealthInterval},
+	} {
+		if d.v <= 0 {
+			return fmt.Errorf("%v = %v must be positive", d.name, d.v)
+		}
+	}
+	for _, n := range []struct {
+		name string
+
+		v int
+	}{
+		{"history_size", c.HistorySize},
+		{"history_page_size", c.HistoryPageSize},
+		{"history_max_page_size", c.HistoryMaxPageSize},
+		{"topic_retention", c.TopicRetention},
+		{"max_topics", c.MaxTopics},
+		{"stream_buffer", c.StreamBuffer},
+		{"subscriber_buffer", c.SubscriberBuffer},
+	} {
+		if n.v < 1 {
+			return fmt.Errorf("%v = %v must be positive", n.name, n.v)
+		}
+	}
+
+	for _, e := range c.Listen {
+		if _, _, err := endpoint.Parse(e); err != nil {
+			return fmt.Errorf("listen: %v", err)
+		}
+	}
+
+	switch {
+	case c.Address == "":
+		return fmt.Errorf("address must be set")
+	case c.MaxConcurrent < 0:
+		return fmt.Errorf("max_concurrent = %v must not be negative", c.MaxConcurrent)
+	case c.Latency < 0:
+		return fmt.Errorf("latency = %v must not be negative", c.Latency)
+	case c.HistoryPageSize > c.HistoryMaxPageSize:
+		return fmt.Errorf("history_page_size = %v must not exceed history_max_page_size = %v",
+			c.HistoryPageSize, c.HistoryMaxPageSize)
+	}
+	if _, err := parseRateLimits(c.RateLimit); err != nil {
+		return fmt.Errorf("rate_limit: %v", err)
+	}
+	if _, err := parseFaults(c.Faults); err != nil {
+		return fmt.Errorf("faults: %v", err)
+	}
+	if c.HealthOverride != "" && c.HealthOverride != healthServing && c.HealthOverride != healthNotServing {
+		return fmt.Errorf("health_override = %q is not serving or not_serving", c.HealthOverride)
+	}
+	if c.LogLevel != "" {
+		if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+			return fmt.Errorf("log_level: %v", err)
+		}
+	}
+	return nil
+}
+
+// Node is one echo server. It can be paused, which holds every call like a
+// stopped process would, & promoted to or demoted from leader.
+type Node struct {
+	cfg Config
+
+	echo *EchoServer
+
+	log *logging.Logger
+
+	history *historyStore
+
+	gate *gate
+
+	limiter *rateLimiter
+
+	faults *faultInjector
+
+	health *HealthCheckServer
+
+	load *loadTracker
+
+	pubsub *PubSubServer
+
+	calls *callMetrics
+
+	started time.Time
+
+	// serializes reloads
+	reloadMu sync.Mutex
+
+	s *grpc.Server
+
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+
+	https []*http.Server
+
+	stopped bool
+}
+
+// New creates a node w/ its services registered, Serve starts it.
+func New(cfg Config) (*Node, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Default()
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	echoServer := newEchoServer(cfg, logger)
+	history, err := newHistoryStore(cfg.HistorySize, cfg.HistoryPageSize, cfg.HistoryMaxPageSize,
+		cfg.HistoryFile, echoServer.log.With("component", "history"))
+	if err != nil {
+		return nil, err
+	}
+
+	echoServer.history = history
+	echoServer.feed = newTickFeed(cfg.StreamBuffer, cfg.SubscriberBuffer)
+	go echoServer.feed.run(cfg.TickInterval, echoServer)
+	load := newLoadTracker(echoServer.id, cfg.MaxConcurrent, echoServer.log.With("component", "load"))
+	go load.runCPUSampler(cfg.LoadSampleInterval, echoServer.shutdownCh)
+
+	g := newGate()
+	calls := &callLogger{echoServer: echoServer, metrics: newCallMetrics()}
+	var pb pushback
+
+	// both are in place w/o limits or faults, so that Reload can add some
+	limits, _ := parseRateLimits(cfg.RateLimit)
+	limiter := newRateLimiter(limits, cfg.RateLimitIdleTimeout, echoServer.log.With("component", "ratelimit"))
+	go limiter.runSweeper(echoServer.shutdownCh)
+	if cfg.RateLimit != "" {
+		echoServer.log.Info("rate limits", "limits", cfg.RateLimit)
+	}
+	faults, _ := parseFaults(cfg.Faults)
+	injector := newFaultInjector(faults, echoServer.log.With("component", "faults"))
+	if cfg.Faults != "" {
+		echoServer.log.Warn("injecting faults", "faults", cfg.Faults)
+	}
+	// calls failed by a limit or a fault never wait for a slot
+	unary := []grpc.UnaryServerInterceptor{calls.UnaryInterceptor, pb.UnaryInterceptor, g.UnaryInterceptor, history.UnaryInterceptor,
+		limiter.UnaryInterceptor, injector.UnaryInterceptor, load.UnaryInterceptor}
+	stream := []grpc.StreamServerInterceptor{calls.StreamInterceptor, pb.StreamInterceptor, g.StreamInterceptor, history.StreamInterceptor,
+		limiter.StreamInterceptor, injector.StreamInterceptor, load.StreamInterceptor}
+	unary = append(unary, cfg.UnaryInterceptors...)
+	stream = append(stream, cfg.StreamInterceptors...)
+
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
+	api.RegisterEchoServer(s, echoServer)
+	healthcheck := newHealthCheckServer(echoServer, cfg.HealthInterval)
+	healthcheck.setOverride(cfg.HealthOverride)
+	healthgrpc.RegisterHealthServer(s, healthcheck)
+	pubsub := newPubSubServer(echoServer, cfg)
+	api.RegisterPubSubServer(s, pubsub)
+	n := &Node{cfg: cfg, echo: echoServer, log: echoServer.log, history: history, gate: g,
+		limiter: limiter, faults: injector, health: healthcheck, load: load, pubsub: pubsub,
+		calls: calls.metrics, started: time.Now(), s: s}
+	api.RegisterAdminServer(s, newAdminServer(limiter, load, n, echoServer.log))
+	service.RegisterChannelzServiceToServer(s)
+	if cfg.Reflection {
+		reflection.Register(s)
+		echoServer.log.Info("registered reflection service")
+	}
+
+	return n, nil
+}
+
+// ID is the server id this node answers w/.
+func (n *Node) ID() string {
+	return n.echo.id
+}
+
+func (n *Node) Config() Config {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.cfg
+}
+
+func (n *Node) IsLeader() bool {
+	return n.echo.leader()
+}
+
+func (n *Node) SetLeader(isLeader bool) {
+	n.echo.setLeader(isLeader)
+}
+
+// Pause holds every call, new or in progress, until Resume.
+func (n *Node) Pause() {
+	n.gate.pause()
+	n.log.Info("paused")
+}
+
+func (n *Node) Resume() {
+	n.gate.resume()
+	n.log.Info("resumed")
+}
+
+func (n *Node) Paused() bool {
+	return n.gate.isPaused()
+}
+
+// ListenAndServe listens on the configured address & the other endpoints
+// & serves on all of them.
+func (n *Node) ListenAndServe() error {
+	liss, err := n.Listen()
+	if err != nil {
+		return err
+	}
+	return n.Serve(liss...)
+}
+
+// Listen listens on the configured address & the other endpoints, for
+// Serve. None is left open when one fails.
+func (n *Node) Listen() ([]net.Listener, error) {
+	var liss []net.Listener
+	for _, e := range append([]string{n.cfg.Address}, n.cfg.Listen...) {
+		n.log.Info("listening", "addr", e)
+		lis, err := endpoint.Listen(e)
+		if err != nil {
+			for _, l := range liss {
+				l.Close()
+			}
+			return nil, err
+		}
+		liss = append(liss, lis)
+	}
+	return liss, nil
+}
+
+// Serve serves gRPC on each of liss, along w/ the gateway & grpc-web when
+// they are configured, until Stop.
+func (n *Node) Serve(liss ...net.Listener) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	n.mu.Lock()
+	n.cancel = cancel
+	n.mu.Unlock()
+
+	if n.cfg.HTTP != "" {
+		gw, err := newGateway(ctx, endpoint.DialTarget(n.cfg.Address), n.cfg.OpenAPI, n.log.With("component", "gateway"))
+		if err != nil {
+			return err
+		}
+
+		n.log.Info("serving gateway", "addr", n.cfg.HTTP)
+		n.serveHTTP(n.cfg.HTTP, gw)
+	}
+
+	if n.cfg.GrpcWeb != "" {
+		web := newGrpcWebHandler(n.s, n.cfg.CORSOrigins)
+		if endpoint.Same(n.cfg.GrpcWeb, n.cfg.Address) {
+			n.log.Info("grpc-web shares the listeners", "addr", n.cfg.Address)
+			hs := &http.Server{Handler: n.withOps(web)}
+			n.addHTTP(hs)
+			return n.serveAll(liss, hs.Serve)
+		}
+
+		n.log.Info("serving grpc-web", "addr", n.cfg.GrpcWeb)
+		n.serveHTTP(n.cfg.GrpcWeb, web)
+	}
+
+	// HTTP/1.1 on the gRPC listeners is for operators, see opsHandler
+	ops := &http.Server{Handler: n.opsHandler()}
+	n.addHTTP(ops)
+	grpcLiss := make([]net.Listener, len(liss))
+	for i, lis := range liss {
+		m := newProtocolMux(lis)
+		grpcLiss[i] = m.grpc
+		go m.run()
+		go func() {
+			if err := ops.Serve(m.http); err != http.ErrServerClosed && err != errMuxClosed {
+				n.log.Error("serve ops", "addr", m.http.Addr(), "err", err)
+			}
+		}()
+	}
+	return n.serveAll(grpcLiss, n.s.Serve)
+}
+
+// serveAll calls serve on each listener, the first one in the foreground.
+func (n *Node) serveAll(liss []net.Listener, serve func(net.Listener) error) error {
+	if len(liss) == 0 {
+		return fmt.Errorf("no listeners to serve on")
+	}
+
+	for _, lis := range liss[1:] {
+		go func(lis net.Listener) {
+			n.log.Info("started", "addr", lis.Addr())
+			if err := serve(lis); err != nil && err != http.ErrServerClosed {
+				n.log.Error("serve", "addr", lis.Addr(), "err", err)
+			}
+		}(lis)
+	}
+
+	n.log.Info("started", "addr", liss[0].Addr())
+	if err := serve(liss[0]); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (n *Node) addHTTP(hs *http.Server) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.https = append(n.https, hs)
+}
+
+func (n *Node) serveHTTP(addr string, h http.Handler) {
+	hs := &http.Server{Addr: addr, Handler: h}
+	n.addHTTP(hs)
+	go func() {
+		if err := hs.ListenAndServe(); err != http.ErrServerClosed {
+			n.log.Error("serve http", "addr", addr, "err", err)
+		}
+	}()
+}
+
+// Stop closes the listeners & connections of the node at once, like a
+// killed process. A stopped node cannot be served again.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return
+	}
+
+	n.stopped = true
+	n.gate.resume()
+	close(n.echo.shutdownCh)
+	n.s.Stop()
+	n.pubsub.Close()
+	for _, hs := range n.https {
+		hs.Close()
+	}
+	if n.cancel != nil {
+		n.cancel()
+	}
+	n.history.Close()
+	n.log.Info("stopped")
+}
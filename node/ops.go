@@ -0,0 +1,244 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type callKey struct {
+	method string
+
+	code codes.Code
+}
+
+type callStats struct {
+	count int64
+
+	seconds float64
+}
+
+// callMetrics counts the calls handled by method & code for /metrics.
+type callMetrics struct {
+	mu sync.Mutex
+
+	calls map[callKey]*callStats
+}
+
+func newCallMetrics() *callMetrics {
+	return &callMetrics{calls: make(map[callKey]*callStats)}
+}
+
+func (m *callMetrics) observe(method string, code codes.Code, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := callKey{method: method, code: code}
+	s, ok := m.calls[k]
+	if !ok {
+		s = &callStats{}
+		m.calls[k] = s
+	}
+	s.count++
+	s.seconds += d.Seconds()
+}
+
+// snapshot returns the stats ordered by method & code.
+func (m *callMetrics) snapshot() ([]callKey, []callStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]callKey, 0, len(m.calls))
+	for k := range m.calls {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].code < keys[j].code
+	})
+
+	stats := make([]callStats, len(keys))
+	for i, k := range keys {
+		stats[i] = *m.calls[k]
+	}
+	return keys, stats
+}
+
+// opsPaths are the paths of the ops handler.
+var opsPaths = []string{"/healthz", "/readyz", "/metrics", "/status", "/debug/pprof/"}
+
+func isOpsPath(path string) bool {
+	for _, p := range opsPaths {
+		if path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// opsHandler serves the HTTP/1.1 endpoints operators probe a node w/, on
+// its gRPC listeners:
+//
+//	GET /healthz        200 while the process runs
+//	GET /readyz         200 while the health service reports SERVING, else 503
+//	GET /metrics        call counts, load & leadership in the Prometheus text format
+//	GET /status         server id, leadership, streams & load as JSON
+//	GET /debug/pprof/   the net/http/pprof profiles
+//
+// Like gRPC calls they are held while the node is paused.
+func (n *Node) opsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+	mux.HandleFunc("/readyz", n.serveReady)
+	mux.HandleFunc("/metrics", n.serveMetrics)
+	mux.HandleFunc("/status", n.serveStatus)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := n.gate.wait(r.Context()); err != nil {
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// withOps serves the ops paths w/ the ops handler & the others w/ h.
+func (n *Node) withOps(h http.Handler) http.Handler {
+	ops := n.opsHandler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 1 && isOpsPath(r.URL.Path) {
+			ops.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (n *Node) healthStatus(ctx context.Context) healthgrpc.HealthCheckResponse_ServingStatus {
+	resp, err := n.health.Check(ctx, &healthgrpc.HealthCheckRequest{})
+	if err != nil {
+		return healthgrpc.HealthCheckResponse_UNKNOWN
+	}
+	return resp.Status
+}
+
+func (n *Node) serveReady(w http.ResponseWriter, r *http.Request) {
+	if st := n.healthStatus(r.Context()); st != healthgrpc.HealthCheckResponse_SERVING {
+		http.Error(w, fmt.Sprintf("not ready, health is %v", st), http.StatusServiceUnavailable)
+		return
+	}
+	io.WriteString(w, "ready\n")
+}
+
+type nodeStatus struct {
+	ServerID string `json:"server_id"`
+
+	IsLeader bool `json:"is_leader"`
+
+	Health string `json:"health"`
+
+	Paused bool `json:"paused"`
+
+	ActiveStreams int64 `json:"active_streams"`
+
+	InFlight int64 `json:"in_flight"`
+
+	QueueDepth int64 `json:"queue_depth"`
+
+	CPUUtilization float64 `json:"cpu_utilization"`
+
+	// ticks sent to StreamEcho so far
+	Ticks uint64 `json:"ticks"`
+
+	Endpoints []string `json:"endpoints"`
+
+	Started time.Time `json:"started"`
+
+	Uptime string `json:"uptime"`
+}
+
+func (n *Node) serveStatus(w http.ResponseWriter, r *http.Request) {
+	cfg := n.Config()
+	load := n.load.Report()
+	st := nodeStatus{
+		ServerID:       n.ID(),
+		IsLeader:       n.IsLeader(),
+		Health:         n.healthStatus(r.Context()).String(),
+		Paused:         n.Paused(),
+		ActiveStreams:  load.ActiveStreams,
+		InFlight:       load.InFlight,
+		QueueDepth:     load.QueueDepth,
+		CPUUtilization: load.CpuUtilization,
+		Ticks:          n.echo.feed.sent(),
+		Endpoints:      append([]string{cfg.Address}, cfg.Listen...),
+		Started:        n.started,
+		Uptime:         time.Since(n.started).Round(time.Second).String(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(st)
+}
+
+func boolMetric(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (n *Node) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	load := n.load.Report()
+
+	fmt.Fprintf(w, "# HELP echo_server_info Id of the server.\n# TYPE echo_server_info gauge\n")
+	fmt.Fprintf(w, "echo_server_info{server_id=%q} 1\n", n.ID())
+	for _, g := range []struct {
+		name, help string
+
+		v interface{}
+	}{
+		{"echo_is_leader", "1 while the server is the leader.", boolMetric(n.IsLeader())},
+		{"echo_paused", "1 while the server is paused.", boolMetric(n.Paused())},
+		{"echo_in_flight", "Unary calls being handled.", load.InFlight},
+		{"echo_active_streams", "Streams open.", load.ActiveStreams},
+		{"echo_queue_depth", "Unary calls waiting for a slot.", load.QueueDepth},
+		{"echo_cpu_utilization", "Fraction of the cpus used by the process.", load.CpuUtilization},
+		{"echo_goroutines", "Goroutines of the process.", runtime.NumGoroutine()},
+		{"echo_start_time_seconds", "Start time of the server in unix seconds.", n.started.Unix()},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", g.name, g.help, g.name, g.name, g.v)
+	}
+
+	fmt.Fprintf(w, "# HELP echo_ticks_total Ticks sent to StreamEcho.\n# TYPE echo_ticks_total counter\n")
+	fmt.Fprintf(w, "echo_ticks_total %d\n", n.echo.feed.sent())
+
+	keys, stats := n.calls.snapshot()
+	fmt.Fprintf(w, "# HELP echo_calls_total Calls handled by method & code.\n# TYPE echo_calls_total counter\n")
+	for i, k := range keys {
+		fmt.Fprintf(w, "echo_calls_total{method=%q,code=%q} %d\n", k.method, k.code.String(), stats[i].count)
+	}
+	fmt.Fprintf(w, "# HELP echo_call_seconds_total Time spent handling calls by method & code.\n# TYPE echo_call_seconds_total counter\n")
+	for i, k := range keys {
+		fmt.Fprintf(w, "echo_call_seconds_total{method=%q,code=%q} %g\n", k.method, k.code.String(), stats[i].seconds)
+	}
+}
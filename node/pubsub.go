@@ -0,0 +1,428 @@
+package node
+
+import (
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/echoerr"
+	"github.com/1xyz/grpc-playground/endpoint"
+	"github.com/1xyz/grpc-playground/logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"sync"
+	"time"
+)
+
+// topicLog orders the messages of a topic on the leader, retaining the
+// last size of them for replay.
+type topicLog struct {
+	mu sync.Mutex
+
+	size int
+
+	// messages buffered per subscriber before it is dropped as too slow
+	subBuffer int
+
+	// retained messages, ordered by sequence
+	messages []*api.Message
+
+	nextSeq uint64
+
+	subs map[chan *api.Message]bool
+}
+
+func newTopicLog(size int, subBuffer int) *topicLog {
+	return &topicLog{size: size, subBuffer: subBuffer, nextSeq: 1, subs: make(map[chan *api.Message]bool)}
+}
+
+// append sequences m & hands it to the subscribers. A subscriber whose
+// buffer is full has its channel closed.
+func (t *topicLog) append(m *api.Message) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	m.Sequence = t.nextSeq
+	t.nextSeq++
+	t.messages = append(t.messages, m)
+	if len(t.messages) > t.size {
+		t.messages = t.messages[len(t.messages)-t.size:]
+	}
+
+	for ch := range t.subs {
+		select {
+		case ch <- m:
+		default:
+			delete(t.subs, ch)
+			close(ch)
+		}
+	}
+	return m.Sequence
+}
+
+// subscribe returns the retained messages from from on & a channel of the
+// messages after them.
+func (t *topicLog) subscribe(from uint64) ([]*api.Message, chan *api.Message, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var replay []*api.Message
+	if from > 0 && from < t.nextSeq {
+		oldest := t.nextSeq
+		if len(t.messages) > 0 {
+			oldest = t.messages[0].Sequence
+		}
+		if from < oldest {
+			return nil, nil, status.Errorf(codes.OutOfRange,
+				"sequence %d was evicted, oldest retained is %d", from, oldest)
+		}
+		replay = append(replay, t.messages[from-oldest:]...)
+	}
+
+	ch := make(chan *api.Message, t.subBuffer)
+	t.subs[ch] = true
+	return replay, ch, nil
+}
+
+func (t *topicLog) unsubscribe(ch chan *api.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.subs[ch] {
+		delete(t.subs, ch)
+		close(ch)
+	}
+}
+
+// empty reports whether t has neither subscribers nor retained messages,
+// i.e. it can be dropped w/o anyone noticing.
+func (t *topicLog) empty() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.subs) == 0 && len(t.messages) == 0
+}
+
+// PubSubServer sequences & fans out messages when it runs on the leader. On
+// other servers it forwards publishes & subscriptions to the leader, found
+// by asking the peers.
+type PubSubServer struct {
+	api.UnimplementedPubSubServer
+
+	echoServer *EchoServer
+
+	peers []string
+
+	dialOpts []grpc.DialOption
+
+	logSize int
+
+	subBuffer int
+
+	maxTopics int
+
+	// timeout of asking a peer whether it is the leader
+	peerTimeout time.Duration
+
+	mu sync.Mutex
+
+	// topics w/ subscribers or retained messages
+	topics map[string]*topicLog
+
+	conns map[string]*grpc.ClientConn
+
+	// set by Close, no peer is dialed anymore
+	closed bool
+
+	leaderAddr string
+
+	log *logging.Logger
+}
+
+func newPubSubServer(echoServer *EchoServer, cfg Config) *PubSubServer {
+	return &PubSubServer{
+		echoServer:  echoServer,
+		peers:       cfg.Peers,
+		dialOpts:    cfg.DialOptions,
+		log:         echoServer.log.With("component", "pubsub"),
+		logSize:     cfg.TopicRetention,
+		subBuffer:   cfg.SubscriberBuffer,
+		maxTopics:   cfg.MaxTopics,
+		peerTimeout: cfg.PeerTimeout,
+		topics:      make(map[string]*topicLog),
+		conns:       make(map[string]*grpc.ClientConn),
+	}
+}
+
+// withTopic calls f w/ the log of the topic name, created unless there are
+// max_topics already. Topics are not dropped while f runs.
+func (p *PubSubServer) withTopic(name string, f func(t *topicLog) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t, ok := p.topics[name]
+	if !ok {
+		if len(p.topics) >= p.maxTopics {
+			return status.Errorf(codes.ResourceExhausted, "max_topics = %d topics exist already", p.maxTopics)
+		}
+		t = newTopicLog(p.logSize, p.subBuffer)
+		p.topics[name] = t
+	}
+	err := f(t)
+	if t.empty() {
+		delete(p.topics, name)
+	}
+	return err
+}
+
+// unsubscribe unsubscribes ch from the topic name & drops the topic once it
+// is empty.
+func (p *PubSubServer) unsubscribe(name string, t *topicLog, ch chan *api.Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t.unsubscribe(ch)
+	if t.empty() && p.topics[name] == t {
+		delete(p.topics, name)
+	}
+}
+
+// Close closes the connections to the peers.
+func (p *PubSubServer) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for addr, c := range p.conns {
+		c.Close()
+		delete(p.conns, addr)
+	}
+	p.leaderAddr = ""
+}
+
+func (p *PubSubServer) conn(addr string) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c, ok := p.conns[addr]; ok {
+		return c, nil
+	}
+	if p.closed {
+		return nil, status.Error(codes.Unavailable, "server stopped")
+	}
+	c, err := grpc.Dial(endpoint.DialTarget(addr), append([]grpc.DialOption{grpc.WithInsecure()}, p.dialOpts...)...)
+	if err != nil {
+		return nil, err
+	}
+	p.conns[addr] = c
+	return c, nil
+}
+
+// leader returns a client of the leader, asking the peers which one it is
+// unless that is already known.
+func (p *PubSubServer) leader(ctx context.Context) (api.PubSubClient, error) {
+	p.mu.Lock()
+	addr := p.leaderAddr
+	p.mu.Unlock()
+
+	if addr == "" {
+		for _, peer := range p.peers {
+			c, err := p.conn(peer)
+			if err != nil {
+				continue
+			}
+
+			cctx, cancel := context.WithTimeout(ctx, p.peerTimeout)
+			resp, err := api.NewEchoClient(c).IsLeader(cctx, &api.Empty{})
+			cancel()
+			if err == nil && resp.IsLeader {
+				addr = peer
+				break
+			}
+		}
+		if addr == "" {
+			return nil, status.Error(codes.Unavailable, "no leader among peers")
+		}
+
+		p.log.Info("found leader", "peer", addr)
+		p.mu.Lock()
+		p.leaderAddr = addr
+		p.mu.Unlock()
+	}
+
+	c, err := p.conn(addr)
+	if err != nil {
+		return nil, err
+	}
+	return api.NewPubSubClient(c), nil
+}
+
+// forgetLeader makes the next call look for the leader again once err shows
+// it cannot be reached or is not the leader anymore. It reports the latter,
+// when the call is worth forwarding again right away.
+func (p *PubSubServer) forgetLeader(err error) (notLeader bool) {
+	notLeader = echoerr.Reason(err) == echoerr.ReasonNotLeader
+	if status.Code(err) != codes.Unavailable && !notLeader {
+		return false
+	}
+	p.mu.Lock()
+	p.leaderAddr = ""
+	p.mu.Unlock()
+	return notLeader
+}
+
+// forwardedHeader marks the calls a follower forwards to the leader. One
+// that is not the leader anymore, e.g. after a promotion, rejects them
+// instead of forwarding them on, so stale caches cannot bounce calls around.
+const forwardedHeader = "echo-forwarded-by"
+
+// forward returns the context to forward a call of ctx to the leader w/.
+func (p *PubSubServer) forward(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, forwardedHeader, p.echoServer.id)
+}
+
+// checkForwarded fails a call forwarded to this follower.
+func (p *PubSubServer) checkForwarded(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md.Get(forwardedHeader)) == 0 {
+		return nil
+	}
+	return &echoerr.Error{
+		Code:     codes.FailedPrecondition,
+		Message:  "not leader",
+		Reason:   echoerr.ReasonNotLeader,
+		Domain:   echoerr.Domain,
+		Metadata: map[string]string{"server_id": p.echoServer.id, "forwarded_by": md.Get(forwardedHeader)[0]},
+	}
+}
+
+func (p *PubSubServer) Publish(ctx context.Context, req *api.PublishRequest) (*api.PublishResponse, error) {
+	if req.Topic == "" {
+		return nil, status.Error(codes.InvalidArgument, "topic must be set")
+	}
+
+	if !p.echoServer.leader() {
+		if err := p.checkForwarded(ctx); err != nil {
+			return nil, err
+		}
+		for retried := false; ; retried = true {
+			c, err := p.leader(ctx)
+			if err != nil {
+				return nil, err
+			}
+			resp, err := c.Publish(p.forward(ctx), req)
+			if p.forgetLeader(err) && !retried {
+				continue
+			}
+			return resp, err
+		}
+	}
+
+	var seq uint64
+	err := p.withTopic(req.Topic, func(t *topicLog) error {
+		seq = t.append(&api.Message{
+			Topic:          req.Topic,
+			Payload:        req.Payload,
+			PublishedNanos: time.Now().UnixNano(),
+			ClientId:       req.ClientId,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.PublishResponse{Sequence: seq}, nil
+}
+
+func (p *PubSubServer) Subscribe(req *api.SubscribeRequest, stream api.PubSub_SubscribeServer) error {
+	if req.Topic == "" {
+		return status.Error(codes.InvalidArgument, "topic must be set")
+	}
+
+	if !p.echoServer.leader() {
+		if err := p.checkForwarded(stream.Context()); err != nil {
+			return err
+		}
+		return p.relay(req, stream)
+	}
+
+	var t *topicLog
+	var replay []*api.Message
+	var ch chan *api.Message
+	err := p.withTopic(req.Topic, func(tl *topicLog) (err error) {
+		t = tl
+		replay, ch, err = t.subscribe(req.FromSequence)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer p.unsubscribe(req.Topic, t, ch)
+
+	p.log.Info("subscribed", "topic", req.Topic, "client_id", req.ClientId, "from", req.FromSequence)
+	for _, m := range replay {
+		if err := stream.Send(m); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case m, ok := <-ch:
+			if !ok {
+				return status.Errorf(codes.ResourceExhausted,
+					"subscriber fell more than %d messages behind", t.subBuffer)
+			}
+			if err := stream.Send(m); err != nil {
+				return err
+			}
+
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+
+		case <-p.echoServer.shutdownCh:
+			return nil
+		}
+	}
+}
+
+// relay passes the subscription of the leader on to stream. It subscribes
+// again once when the cached leader turns out not to be the leader anymore
+// before any message was relayed.
+func (p *PubSubServer) relay(req *api.SubscribeRequest, stream api.PubSub_SubscribeServer) error {
+	for retried := false; ; retried = true {
+		retry, err := p.relayOnce(req, stream)
+		if retry && !retried {
+			continue
+		}
+		return err
+	}
+}
+
+// relayOnce relays the subscription of the cached leader, reporting whether
+// it failed early for the leader having changed.
+func (p *PubSubServer) relayOnce(req *api.SubscribeRequest, stream api.PubSub_SubscribeServer) (retry bool, err error) {
+	c, err := p.leader(stream.Context())
+	if err != nil {
+		return false, err
+	}
+
+	up, err := c.Subscribe(p.forward(stream.Context()), req)
+	if err != nil {
+		return p.forgetLeader(err), err
+	}
+
+	relayed := false
+	for {
+		m, err := up.Recv()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return p.forgetLeader(err) && !relayed, err
+		}
+		if err := stream.Send(m); err != nil {
+			return false, err
+		}
+		relayed = true
+	}
+}
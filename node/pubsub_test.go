@@ -0,0 +1,125 @@
+package node
+
+import (
+	"github.com/1xyz/grpc-playground/api"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func dialNode(t *testing.T, addr string) *grpc.ClientConn {
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("dial: err = %v", err)
+	}
+	return conn
+}
+
+func topics(p *PubSubServer) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.topics)
+}
+
+func TestPubSubDropsEmptyTopics(t *testing.T) {
+	n, addr := startNode(t, nil)
+	defer n.Stop()
+	conn := dialNode(t, addr)
+	defer conn.Close()
+	client := api.NewPubSubClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sctx, unsubscribe := context.WithCancel(ctx)
+	sub, err := client.Subscribe(sctx, &api.SubscribeRequest{Topic: "a"})
+	if err != nil {
+		t.Fatalf("subscribe: err = %v", err)
+	}
+	if _, err := client.Publish(ctx, &api.PublishRequest{Topic: "b", Payload: []byte("x")}); err != nil {
+		t.Fatalf("publish: err = %v", err)
+	}
+	for topics(n.pubsub) != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// a w/o subscribers & messages goes, b keeps its retained message
+	unsubscribe()
+	if _, err := sub.Recv(); status.Code(err) != codes.Canceled {
+		t.Fatalf("recv: err = %v, want Canceled", err)
+	}
+	for topics(n.pubsub) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	n.pubsub.mu.Lock()
+	_, ok := n.pubsub.topics["b"]
+	n.pubsub.mu.Unlock()
+	if !ok {
+		t.Fatalf("topic b was dropped w/ a retained message")
+	}
+}
+
+func TestPubSubMaxTopics(t *testing.T) {
+	n, addr := startNode(t, func(cfg *Config) {
+		cfg.MaxTopics = 2
+	})
+	defer n.Stop()
+	conn := dialNode(t, addr)
+	defer conn.Close()
+	client := api.NewPubSubClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, topic := range []string{"a", "b", "a"} {
+		if _, err := client.Publish(ctx, &api.PublishRequest{Topic: topic}); err != nil {
+			t.Fatalf("publish %v: err = %v", topic, err)
+		}
+	}
+	if _, err := client.Publish(ctx, &api.PublishRequest{Topic: "c"}); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("publish c: err = %v, want ResourceExhausted beyond max_topics", err)
+	}
+	sub, err := client.Subscribe(ctx, &api.SubscribeRequest{Topic: "c"})
+	if err == nil {
+		_, err = sub.Recv()
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("subscribe c: err = %v, want ResourceExhausted beyond max_topics", err)
+	}
+}
+
+// TestPubSubStopClosesPeers checks that a stopped follower closes its
+// connection to the leader it forwarded to.
+func TestPubSubStopClosesPeers(t *testing.T) {
+	leader, leaderAddr := startNode(t, nil)
+	defer leader.Stop()
+	follower, addr := startNode(t, func(cfg *Config) {
+		cfg.Leader = false
+		cfg.Peers = []string{leaderAddr}
+	})
+
+	conn := dialNode(t, addr)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := api.NewPubSubClient(conn).Publish(ctx, &api.PublishRequest{Topic: "a"}); err != nil {
+		t.Fatalf("publish: err = %v", err)
+	}
+
+	follower.pubsub.mu.Lock()
+	peer := follower.pubsub.conns[leaderAddr]
+	follower.pubsub.mu.Unlock()
+	if peer == nil {
+		t.Fatalf("no connection to the leader after forwarding")
+	}
+
+	follower.Stop()
+	if s := peer.GetState(); s != connectivity.Shutdown {
+		t.Fatalf("connection to the leader is %v after Stop, want SHUTDOWN", s)
+	}
+	if _, err := follower.pubsub.conn(leaderAddr); status.Code(err) != codes.Unavailable {
+		t.Fatalf("conn after Stop: err = %v, want Unavailable", err)
+	}
+}
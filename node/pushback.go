@@ -0,0 +1,45 @@
+package node
+
+import (
+	"github.com/1xyz/grpc-playground/echoerr"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// pushbackMD returns the trailer telling clients whether & when to retry a
+// call that failed w/ err, nil when err leaves that to them.
+func pushbackMD(err error) metadata.MD {
+	e, ok := echoerr.FromError(err)
+	if !ok {
+		return nil
+	}
+	ms, ok := e.Pushback()
+	if !ok {
+		return nil
+	}
+	return metadata.Pairs(echoerr.PushbackTrailer, ms)
+}
+
+// pushback sets the retry pushback trailer of failed calls, from the retry
+// advice of their errors, so that grpc clients wait as long as the server
+// asks or do not retry at all instead of backing off on their own.
+type pushback struct{}
+
+func (pushback) UnaryInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if md := pushbackMD(err); md != nil {
+		grpc.SetTrailer(ctx, md)
+	}
+	return resp, err
+}
+
+func (pushback) StreamInterceptor(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := handler(srv, ss)
+	if md := pushbackMD(err); md != nil {
+		ss.SetTrailer(md)
+	}
+	return err
+}
@@ -0,0 +1,359 @@
+package node
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/echoerr"
+	"github.com/1xyz/grpc-playground/logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// globalMethod keys the limit shared by all methods of a client.
+const globalMethod = "*"
+
+// controlServices serve operators & balancers rather than clients. A *
+// limit leaves them alone, faults never apply to them & they take no slot
+// of max_concurrent, so that a client out of quota still passes its health
+// checks & an operator can still reload the config of a busy server.
+var controlServices = []string{
+	"/grpc.health.v1.Health/",
+	"/api.Admin/",
+	"/grpc.reflection.v1alpha.ServerReflection/",
+	"/grpc.channelz.v1.Channelz/",
+}
+
+func isControlMethod(fullMethod string) bool {
+	for _, s := range controlServices {
+		if strings.HasPrefix(fullMethod, s) {
+			return true
+		}
+	}
+	return false
+}
+
+type rateLimit struct {
+	// tokens added per second
+	rate float64
+
+	burst int
+}
+
+// parseRateLimits parses a spec like "*=100:200,Echo=10:20" into limits
+// keyed by method, each given as rate:burst. A method is a full method name
+// (/api.Echo/Echo) or just its name (Echo), * limits all methods together.
+func parseRateLimits(spec string) (map[string]rateLimit, error) {
+	limits := make(map[string]rateLimit)
+	if spec == "" {
+		return limits, nil
+	}
+
+	for _, e := range strings.Split(spec, ",") {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid rate limit %q, want method=rate:burst", e)
+		}
+
+		rb := strings.SplitN(kv[1], ":", 2)
+		rate, err := strconv.ParseFloat(rb[0], 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate in %q", e)
+		}
+
+		burst := int(math.Ceil(rate))
+		if len(rb) == 2 {
+			if burst, err = strconv.Atoi(rb[1]); err != nil || burst < 1 {
+				return nil, fmt.Errorf("invalid burst in %q", e)
+			}
+		}
+		limits[kv[0]] = rateLimit{rate: rate, burst: burst}
+	}
+	return limits, nil
+}
+
+type tokenBucket struct {
+	limit rateLimit
+
+	tokens float64
+
+	last time.Time
+
+	allowed int64
+
+	limited int64
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.rate)
+	b.last = now
+}
+
+// take removes a token from the bucket, or tells how long until one is
+// available.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		b.allowed++
+		return true, 0
+	}
+
+	b.limited++
+	wait := (1 - b.tokens) / b.limit.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+type bucketKey struct {
+	method string
+
+	key string
+}
+
+// rateLimiter keeps a token bucket per caller for the global limit and one
+// per caller & method for every method w/ a limit of its own. A call has to
+// get a token from each bucket that applies to it.
+type rateLimiter struct {
+	mu sync.Mutex
+
+	limits map[string]rateLimit
+
+	buckets map[bucketKey]*tokenBucket
+
+	// idle buckets that have filled up again are dropped after this long
+	idleTimeout time.Duration
+
+	log *logging.Logger
+}
+
+func newRateLimiter(limits map[string]rateLimit, idleTimeout time.Duration, log *logging.Logger) *rateLimiter {
+	return &rateLimiter{
+		limits:      limits,
+		buckets:     make(map[bucketKey]*tokenBucket),
+		idleTimeout: idleTimeout,
+		log:         log,
+	}
+}
+
+// setLimits replaces the limits, the buckets start over from the new ones.
+func (r *rateLimiter) setLimits(limits map[string]rateLimit) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limits = limits
+	r.buckets = make(map[bucketKey]*tokenBucket)
+}
+
+// methodLimit finds the limit of fullMethod, by full or short name.
+func (r *rateLimiter) methodLimit(fullMethod string) (string, rateLimit, bool) {
+	if l, ok := r.limits[fullMethod]; ok {
+		return fullMethod, l, true
+	}
+	short := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	if l, ok := r.limits[short]; ok {
+		return fullMethod, l, true
+	}
+	return "", rateLimit{}, false
+}
+
+// Allow takes a token for key from the buckets that apply to fullMethod, the
+// * one unless fullMethod belongs to the controlServices. On failure it
+// returns how long the caller should wait before retrying.
+func (r *rateLimiter) Allow(fullMethod string, key string) (bool, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	keys := make([]bucketKey, 0, 2)
+	if _, ok := r.limits[globalMethod]; ok && !isControlMethod(fullMethod) {
+		keys = append(keys, bucketKey{method: globalMethod, key: key})
+	}
+	if m, _, ok := r.methodLimit(fullMethod); ok {
+		keys = append(keys, bucketKey{method: m, key: key})
+	}
+
+	var wait time.Duration
+	for _, k := range keys {
+		b := r.bucket(k, now)
+		b.refill(now)
+		if b.tokens < 1 {
+			_, w := b.take(now)
+			if w > wait {
+				wait = w
+			}
+		}
+	}
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, k := range keys {
+		r.buckets[k].take(now)
+	}
+	return true, 0
+}
+
+func (r *rateLimiter) bucket(k bucketKey, now time.Time) *tokenBucket {
+	b, ok := r.buckets[k]
+	if !ok {
+		l := r.limits[globalMethod]
+		if k.method != globalMethod {
+			_, l, _ = r.methodLimit(k.method)
+		}
+		b = &tokenBucket{limit: l, tokens: float64(l.burst), last: now}
+		r.buckets[k] = b
+	}
+	return b
+}
+
+// sweep drops the buckets that have been idle long enough to be full again.
+func (r *rateLimiter) sweep(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for k, b := range r.buckets {
+		if now.Sub(b.last) < r.idleTimeout {
+			continue
+		}
+		if b.refill(now); b.tokens >= float64(b.limit.burst) {
+			delete(r.buckets, k)
+		}
+	}
+}
+
+func (r *rateLimiter) runSweeper(shutdownCh chan bool) {
+	ticker := time.NewTicker(r.idleTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case t := <-ticker.C:
+			r.sweep(t)
+
+		case <-shutdownCh:
+			return
+		}
+	}
+}
+
+// State returns the configured limits & the current buckets.
+func (r *rateLimiter) State() *api.RateLimitsResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	resp := &api.RateLimitsResponse{}
+	for m, l := range r.limits {
+		resp.Limits = append(resp.Limits, &api.RateLimit{
+			Method: m,
+			Rate:   l.rate,
+			Burst:  int32(l.burst),
+		})
+	}
+
+	now := time.Now()
+	for k, b := range r.buckets {
+		b.refill(now)
+		resp.Buckets = append(resp.Buckets, &api.RateLimitBucket{
+			Method:  k.method,
+			Key:     k.key,
+			Tokens:  b.tokens,
+			Allowed: b.allowed,
+			Limited: b.limited,
+		})
+	}
+
+	sort.Slice(resp.Limits, func(i, j int) bool { return resp.Limits[i].Method < resp.Limits[j].Method })
+	sort.Slice(resp.Buckets, func(i, j int) bool {
+		bi, bj := resp.Buckets[i], resp.Buckets[j]
+		if bi.Key != bj.Key {
+			return bi.Key < bj.Key
+		}
+		return bi.Method < bj.Method
+	})
+	return resp
+}
+
+// limitError is the error returned to a limited caller, RetryInfo tells it
+// when a token will be available.
+func limitError(fullMethod string, key string, wait time.Duration) error {
+	return &echoerr.Error{
+		Code:       codes.ResourceExhausted,
+		Message:    fmt.Sprintf("rate limit exceeded for %v on %v", key, fullMethod),
+		Reason:     echoerr.ReasonRateLimited,
+		Domain:     echoerr.Domain,
+		Metadata:   map[string]string{"method": fullMethod, "key": key},
+		Retry:      true,
+		RetryDelay: wait,
+		QuotaViolations: []echoerr.QuotaViolation{{
+			Subject:     "caller:" + key,
+			Description: fmt.Sprintf("no tokens left for %v", fullMethod),
+		}},
+	}
+}
+
+// callerKey identifies the caller by the client_id of its request, falling
+// back to the host of its peer address.
+func callerKey(ctx context.Context, req interface{}) string {
+	if r, ok := req.(interface{ GetClientId() string }); ok && r.GetClientId() != "" {
+		return r.GetClientId()
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return "unknown"
+}
+
+func (r *rateLimiter) UnaryInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	key := callerKey(ctx, req)
+	if ok, wait := r.Allow(info.FullMethod, key); !ok {
+		r.log.Debug("rate limited", "key", key, "method", info.FullMethod, "wait", wait)
+		return nil, limitError(info.FullMethod, key, wait)
+	}
+	return handler(ctx, req)
+}
+
+func (r *rateLimiter) StreamInterceptor(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &limitedStream{ServerStream: ss, limiter: r, method: info.FullMethod})
+}
+
+// limitedStream applies the limit once the first request message, which
+// carries the client_id, is received.
+type limitedStream struct {
+	grpc.ServerStream
+
+	limiter *rateLimiter
+
+	method string
+
+	checked bool
+}
+
+func (s *limitedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if s.checked {
+		return nil
+	}
+
+	s.checked = true
+	key := callerKey(s.Context(), m)
+	if ok, wait := s.limiter.Allow(s.method, key); !ok {
+		s.limiter.log.Debug("rate limited", "key", key, "method", s.method, "wait", wait)
+		return limitError(s.method, key, wait)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package node
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"reflect"
+	"strings"
+)
+
+// settings Reload can change while the node runs
+var tunables = map[string]bool{
+	"tick_interval":   true,
+	"faults":          true,
+	"rate_limit":      true,
+	"health_override": true,
+	"log_level":       true,
+}
+
+// diffConfig returns the settings that differ between from & to, in the
+// order of the Config fields.
+func diffConfig(from Config, to Config) []*api.ConfigChange {
+	var changes []*api.ConfigChange
+	f, t := reflect.ValueOf(from), reflect.ValueOf(to)
+	for i := 0; i < f.NumField(); i++ {
+		name := strings.Split(f.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fv, tv := f.Field(i), t.Field(i)
+		if fv.Kind() == reflect.Slice && fv.Len() == 0 && tv.Len() == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(fv.Interface(), tv.Interface()) {
+			changes = append(changes, &api.ConfigChange{
+				Setting: name,
+				From:    fmt.Sprint(fv.Interface()),
+				To:      fmt.Sprint(tv.Interface()),
+			})
+		}
+	}
+	return changes
+}
+
+// Reload applies the tunable settings of cfg at once, w/o dropping
+// connections, & returns the settings it changed. cfg is rejected as a
+// whole, w/ InvalidArgument when it is invalid & w/ FailedPrecondition when
+// it changes a setting that needs a restart.
+func (n *Node) Reload(cfg Config) ([]*api.ConfigChange, error) {
+	n.reloadMu.Lock()
+	defer n.reloadMu.Unlock()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	cur := n.Config()
+	if cfg.LogLevel != "" {
+		// the level may have been changed w/ SetLogLevel since
+		cur.LogLevel = n.log.Level().String()
+	}
+	changes := diffConfig(cur, cfg)
+	for _, c := range changes {
+		if !tunables[c.Setting] {
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"%v cannot change w/o a restart, from %v to %v", c.Setting, c.From, c.To)
+		}
+	}
+
+	// validated, so none of these can fail anymore
+	limits, _ := parseRateLimits(cfg.RateLimit)
+	faults, _ := parseFaults(cfg.Faults)
+	for _, c := range changes {
+		switch c.Setting {
+		case "tick_interval":
+			n.echo.feed.setInterval(cfg.TickInterval)
+		case "faults":
+			n.faults.set(faults)
+		case "rate_limit":
+			n.limiter.setLimits(limits)
+		case "health_override":
+			n.health.setOverride(cfg.HealthOverride)
+		case "log_level":
+			level, _ := logging.ParseLevel(cfg.LogLevel)
+			n.log.SetLevel(level)
+		}
+	}
+
+	n.mu.Lock()
+	n.cfg.TickInterval = cfg.TickInterval
+	n.cfg.Faults = cfg.Faults
+	n.cfg.RateLimit = cfg.RateLimit
+	n.cfg.HealthOverride = cfg.HealthOverride
+	n.cfg.LogLevel = cfg.LogLevel
+	n.mu.Unlock()
+	return changes, nil
+}
+
+// ReloadConfig reads the config w/ the Loader of the node & reloads it,
+// logging the outcome.
+func (n *Node) ReloadConfig() ([]*api.ConfigChange, error) {
+	loader := n.Config().Loader
+	if loader == nil {
+		return nil, status.Error(codes.Unimplemented, "this server has no config to reload")
+	}
+
+	cfg, err := loader()
+	if err == nil {
+		var changes []*api.ConfigChange
+		if changes, err = n.Reload(cfg); err == nil {
+			kv := make([]interface{}, 0, 2*len(changes)+2)
+			kv = append(kv, "changes", len(changes))
+			for _, c := range changes {
+				kv = append(kv, c.Setting, c.To)
+			}
+			level := logging.WarnLevel
+			if len(changes) == 0 {
+				level = logging.InfoLevel
+			}
+			n.log.Log(level, "config reloaded", kv...)
+			return changes, nil
+		}
+	}
+
+	if _, ok := status.FromError(err); !ok {
+		err = status.Error(codes.InvalidArgument, err.Error())
+	}
+	n.log.Error("config rejected", "err", status.Convert(err).Message())
+	return nil, err
+}
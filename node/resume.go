@@ -0,0 +1,171 @@
+package node
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type tick struct {
+	seq uint64
+
+	t time.Time
+
+	hlc *api.HybridTimestamp
+}
+
+// tickFeed produces the ticks every StreamEcho sends, numbered by a server
+// wide sequence. It buffers the last size ticks so that a stream resumed
+// after a disconnect can be sent the ticks it missed.
+type tickFeed struct {
+	mu sync.Mutex
+
+	size int
+
+	// ticks buffered per subscriber before it is dropped as too slow
+	subBuffer int
+
+	// buffered ticks, ordered by seq
+	ticks []*tick
+
+	nextSeq uint64
+
+	subs map[chan *tick]bool
+
+	// new periods of the ticks, for run
+	intervalCh chan time.Duration
+}
+
+func newTickFeed(size int, subBuffer int) *tickFeed {
+	return &tickFeed{
+		size:       size,
+		subBuffer:  subBuffer,
+		nextSeq:    1,
+		subs:       make(map[chan *tick]bool),
+		intervalCh: make(chan time.Duration, 1),
+	}
+}
+
+func (f *tickFeed) run(d time.Duration, es *EchoServer) {
+	ticker := time.NewTicker(d)
+	defer func() { ticker.Stop() }()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.append(es.wallTime(), es.clock.Now())
+
+		case d := <-f.intervalCh:
+			ticker.Stop()
+			ticker = time.NewTicker(d)
+
+		case <-es.shutdownCh:
+			return
+		}
+	}
+}
+
+// sent returns the number of ticks sent so far.
+func (f *tickFeed) sent() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.nextSeq - 1
+}
+
+// setInterval changes the period of the ticks, the next one comes d after
+// the change. Calls must not be concurrent.
+func (f *tickFeed) setInterval(d time.Duration) {
+	select {
+	case <-f.intervalCh:
+	default:
+	}
+	f.intervalCh <- d
+}
+
+func (f *tickFeed) append(t time.Time, hlc *api.HybridTimestamp) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tk := &tick{seq: f.nextSeq, t: t, hlc: hlc}
+	f.nextSeq++
+	f.ticks = append(f.ticks, tk)
+	if len(f.ticks) > f.size {
+		f.ticks = f.ticks[len(f.ticks)-f.size:]
+	}
+
+	for ch := range f.subs {
+		select {
+		case ch <- tk:
+		default:
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns the buffered ticks from from on & a channel of the
+// ticks after them, from 0 starts w/ the next tick.
+func (f *tickFeed) subscribe(from uint64) ([]*tick, chan *tick, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var replay []*tick
+	if from > 0 && from < f.nextSeq {
+		oldest := f.nextSeq
+		if len(f.ticks) > 0 {
+			oldest = f.ticks[0].seq
+		}
+		if from < oldest {
+			return nil, nil, status.Errorf(codes.OutOfRange,
+				"tick %d was evicted, oldest buffered is %d", from, oldest)
+		}
+		replay = append(replay, f.ticks[from-oldest:]...)
+	}
+
+	ch := make(chan *tick, f.subBuffer)
+	f.subs[ch] = true
+	return replay, ch, nil
+}
+
+func (f *tickFeed) unsubscribe(ch chan *tick) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs[ch] {
+		delete(f.subs, ch)
+		close(ch)
+	}
+}
+
+// A resume token is the server id & sequence of a tick, so that a token of
+// another server, or of an earlier run of this one, is not mistaken for one
+// of ours.
+func encodeResumeToken(serverId string, seq uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%d", serverId, seq)))
+}
+
+func decodeResumeToken(token string, serverId string) (uint64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, status.Error(codes.InvalidArgument, "malformed resume_token")
+	}
+
+	i := strings.LastIndex(string(b), ":")
+	if i < 0 {
+		return 0, status.Error(codes.InvalidArgument, "malformed resume_token")
+	}
+	seq, err := strconv.ParseUint(string(b[i+1:]), 10, 64)
+	if err != nil {
+		return 0, status.Error(codes.InvalidArgument, "malformed resume_token")
+	}
+	if string(b[:i]) != serverId {
+		return 0, status.Errorf(codes.FailedPrecondition,
+			"resume_token is of server %v, not %v", string(b[:i]), serverId)
+	}
+	return seq, nil
+}
@@ -0,0 +1,130 @@
+package node
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/net/http2"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// connections that have not sent enough to tell their protocol by then are
+// closed
+const sniffTimeout = 10 * time.Second
+
+var errMuxClosed = errors.New("listener closed")
+
+// protocolMux splits the connections of a listener by their first bytes.
+// Those starting w/ the HTTP/2 client preface, which is what gRPC sends,
+// are accepted by grpc & all others, taken for HTTP/1.1, by http. Closing
+// either closes the listener.
+type protocolMux struct {
+	root net.Listener
+
+	grpc *muxListener
+
+	http *muxListener
+
+	once sync.Once
+
+	// closed along w/ root
+	done chan struct{}
+}
+
+func newProtocolMux(root net.Listener) *protocolMux {
+	m := &protocolMux{root: root, done: make(chan struct{})}
+	m.grpc = &muxListener{m: m, conns: make(chan net.Conn)}
+	m.http = &muxListener{m: m, conns: make(chan net.Conn)}
+	return m
+}
+
+// run accepts connections until the listener is closed.
+func (m *protocolMux) run() {
+	for {
+		c, err := m.root.Accept()
+		if err != nil {
+			m.close()
+			return
+		}
+		go m.sniff(c)
+	}
+}
+
+func (m *protocolMux) close() {
+	m.once.Do(func() {
+		close(m.done)
+		m.root.Close()
+	})
+}
+
+// sniff reads as much of c as needed to tell whether it starts w/ the
+// HTTP/2 preface & hands it, w/ the bytes read put back, to a listener.
+func (m *protocolMux) sniff(c net.Conn) {
+	preface := []byte(http2.ClientPreface)
+	buf := make([]byte, 0, len(preface))
+	isHTTP2 := true
+
+	c.SetReadDeadline(time.Now().Add(sniffTimeout))
+	for len(buf) < len(preface) {
+		n, err := c.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if !bytes.HasPrefix(preface, buf) {
+			isHTTP2 = false
+			break
+		}
+		if err != nil {
+			c.Close()
+			return
+		}
+	}
+	c.SetReadDeadline(time.Time{})
+
+	l := m.http
+	if isHTTP2 {
+		l = m.grpc
+	}
+	sc := &sniffedConn{Conn: c, r: io.MultiReader(bytes.NewReader(buf), c)}
+	select {
+	case l.conns <- sc:
+	case <-m.done:
+		c.Close()
+	}
+}
+
+// muxListener is one side of a protocolMux.
+type muxListener struct {
+	m *protocolMux
+
+	conns chan net.Conn
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.m.done:
+		return nil, errMuxClosed
+	}
+}
+
+func (l *muxListener) Close() error {
+	l.m.close()
+	return nil
+}
+
+func (l *muxListener) Addr() net.Addr {
+	return l.m.root.Addr()
+}
+
+// sniffedConn reads the bytes consumed by sniffing again before the rest.
+type sniffedConn struct {
+	net.Conn
+
+	r io.Reader
+}
+
+func (c *sniffedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
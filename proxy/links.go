@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/chaos"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strconv"
+)
+
+// links are the proxies of the proxy command, one per route. It implements
+// the Chaos control service & backs the prompt.
+type links struct {
+	api.UnimplementedChaosServer
+
+	listens []string
+
+	proxies []*chaos.Proxy
+}
+
+func (l *links) link(i int32) (*chaos.Proxy, error) {
+	if i < 0 || int(i) >= len(l.proxies) {
+		return nil, status.Errorf(codes.InvalidArgument, "no link %d, there are %d", i, len(l.proxies))
+	}
+	return l.proxies[i], nil
+}
+
+// indexes parses a link index or all.
+func (l *links) indexes(arg string) ([]int32, error) {
+	if arg == "all" {
+		all := make([]int32, len(l.proxies))
+		for i := range all {
+			all[i] = int32(i)
+		}
+		return all, nil
+	}
+
+	i, err := strconv.Atoi(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link %q", arg)
+	}
+	if _, err := l.link(int32(i)); err != nil {
+		return nil, err
+	}
+	return []int32{int32(i)}, nil
+}
+
+func (l *links) status(i int32) *api.Link {
+	p := l.proxies[i]
+	active, accepted := p.Conns()
+	return &api.Link{
+		Index:         i,
+		Listen:        l.listens[i],
+		Target:        p.Target(),
+		Faults:        p.Faults().Proto(),
+		ActiveConns:   active,
+		AcceptedConns: accepted,
+	}
+}
+
+func (l *links) ListLinks(ctx context.Context, e *api.Empty) (*api.ListLinksResponse, error) {
+	resp := &api.ListLinksResponse{}
+	for i := range l.proxies {
+		resp.Links = append(resp.Links, l.status(int32(i)))
+	}
+	return resp, nil
+}
+
+func (l *links) SetFaults(ctx context.Context, req *api.SetFaultsRequest) (*api.Link, error) {
+	p, err := l.link(req.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	f := chaos.FaultsFromProto(req.Faults)
+	if f.ResetRate < 0 || f.ResetRate > 1 {
+		return nil, status.Errorf(codes.InvalidArgument, "reset_rate %v is not in [0, 1]", f.ResetRate)
+	}
+	p.SetFaults(f)
+	return l.status(req.Index), nil
+}
+
+func (l *links) Drop(ctx context.Context, req *api.DropRequest) (*api.Link, error) {
+	p, err := l.link(req.Index)
+	if err != nil {
+		return nil, err
+	}
+	p.Drop(req.WithReset)
+	return l.status(req.Index), nil
+}
+
+func (l *links) Close() {
+	for _, p := range l.proxies {
+		p.Close()
+	}
+}
+
+func formatLink(link *api.Link) string {
+	return fmt.Sprintf("%d\t%s\t%s\t%d/%d\t%v\t", link.Index, link.Listen, link.Target,
+		link.ActiveConns, link.AcceptedConns, chaos.FaultsFromProto(link.Faults))
+}
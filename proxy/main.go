@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/chaos"
+	"github.com/1xyz/grpc-playground/endpoint"
+	"github.com/1xyz/grpc-playground/logging"
+	"github.com/docopt/docopt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+const promptHelp = `commands:
+   status                  List the links, their open/accepted connections & faults.
+   set <i> [<fault>...]    Replace the faults of link i, e.g. set 0 latency=100ms jitter=20ms.
+   heal <i>                Clear the faults of link i.
+   drop <i>                Close the open connections of link i.
+   reset <i>               Reset the open connections of link i.
+   help                    Print this.
+   quit                    Close all links & exit.
+
+   i is a link index or all.
+
+faults:
+   latency=<duration>      Delay every chunk forwarded, in each direction.
+   jitter=<duration>       Delay each chunk by up to this much more.
+   bandwidth=<bytes>       Limit each direction of a connection to bytes per second.
+   reset-rate=<p>          Reset a new connection w/ probability p.
+   blackhole=<direction>   Swallow the bytes going up (client to server), down or both,
+                           up or down alone being a one way partition.`
+
+// readResolverFile reads addresses, one per line, skipping blank lines & #
+// comments.
+func readResolverFile(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0)
+	for _, l := range strings.Split(string(b), "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "#") {
+			addrs = append(addrs, l)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses in %v", path)
+	}
+	return addrs, nil
+}
+
+// writeResolverFile writes the listen addresses one per line, which is what
+// the client's --resolver-file reads.
+func writeResolverFile(path string, listens []string) error {
+	var b strings.Builder
+	b.WriteString("# listen addresses of the proxy command, one per line\n")
+	for _, addr := range listens {
+		b.WriteString(addr + "\n")
+	}
+	return ioutil.WriteFile(path, []byte(b.String()), 0644)
+}
+
+func printLinks(l *links) {
+	resp, _ := l.ListLinks(context.Background(), &api.Empty{})
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LINK\tLISTEN\tTARGET\tCONNS\tFAULTS\t")
+	for _, link := range resp.Links {
+		fmt.Fprintln(w, formatLink(link))
+	}
+	w.Flush()
+}
+
+// runCommand runs one prompt command on the links it names.
+func runCommand(l *links, fields []string) error {
+	indexes, err := l.indexes(fields[1])
+	if err != nil {
+		return err
+	}
+
+	for _, i := range indexes {
+		switch fields[0] {
+		case "set", "heal":
+			f, err := chaos.ParseFaults(fields[2:])
+			if err != nil {
+				return err
+			}
+			_, err = l.SetFaults(context.Background(), &api.SetFaultsRequest{Index: i, Faults: f.Proto()})
+			if err != nil {
+				return err
+			}
+
+		case "drop", "reset":
+			if _, err := l.Drop(context.Background(), &api.DropRequest{Index: i, WithReset: fields[0] == "reset"}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runPrompt reads commands from stdin until quit or EOF.
+func runPrompt(l *links) {
+	sc := bufio.NewScanner(os.Stdin)
+	fmt.Print("proxy> ")
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		switch {
+		case len(fields) == 0:
+
+		case fields[0] == "quit" || fields[0] == "exit":
+			return
+
+		case fields[0] == "status":
+			printLinks(l)
+
+		case fields[0] == "set" && len(fields) >= 2,
+			(fields[0] == "heal" || fields[0] == "drop" || fields[0] == "reset") && len(fields) == 2:
+			if err := runCommand(l, fields); err != nil {
+				fmt.Printf("err = %v\n", status.Convert(err).Message())
+				break
+			}
+			printLinks(l)
+
+		default:
+			fmt.Println(promptHelp)
+		}
+		fmt.Print("proxy> ")
+	}
+}
+
+func main() {
+	usage := `usage: proxy <route>... [--seed=<n>] [--resolver-file=<path>] [--control=<address>] [--no-prompt]
+             [--log-level=<level>] [--log-format=<format>]
+       proxy --from=<path> [--host=<host>] [--base-port=<port>] [--seed=<n>]
+             [--resolver-file=<path>] [--control=<address>] [--no-prompt]
+             [--log-level=<level>] [--log-format=<format>]
+
+Forward each route, given as <listen>=<target>, w/ faults that can be changed
+while it runs. Point the client at the listen addresses to see how it copes.
+Both ends are endpoints like host:port or unix:///path, as the server takes.
+
+options:
+   --from=<path>            Route to each address of this resolver file, e.g. the
+                            one written by the cluster command.
+   --host=<host>            Host to listen on w/ --from [default: localhost].
+   --base-port=<port>       Port of the first route w/ --from, the others follow [default: 12000].
+   --seed=<n>               Seed of the random choices, the same seed makes the same
+                            choices for the same connections [default: 1].
+   --resolver-file=<path>   Write the listen addresses here [default: proxy.servers].
+   --control=<address>      Serve the Chaos control service on this address, or endpoint
+                            [default: :10998].
+   --no-prompt              Do not read commands from stdin, run until interrupted.
+   --log-level=<level>      Log debug, info, warn or error & above [default: info].
+   --log-format=<format>    Log records as logfmt or json [default: logfmt].
+
+` + promptHelp
+	parser := &docopt.Parser{}
+	args, err := parser.ParseArgs(usage, nil, "1.0")
+	if err != nil {
+		logging.Default().Error("invalid arguments", "err", err)
+		return
+	}
+
+	level, _ := args.String("--log-level")
+	format, _ := args.String("--log-format")
+	logger, err := logging.Setup(level, format)
+	if err != nil {
+		logging.Default().Error("invalid arguments", "err", err)
+		return
+	}
+
+	logger.Debug("parsed arguments", "args", args)
+	seed, err := strconv.ParseInt(args["--seed"].(string), 10, 64)
+	if err != nil {
+		logger.Error("invalid --seed")
+		return
+	}
+
+	var listens, targets []string
+	if path, err := args.String("--from"); err == nil {
+		if targets, err = readResolverFile(path); err != nil {
+			logger.Error("read resolver file", "path", path, "err", err)
+			return
+		}
+
+		basePort, err := args.Int("--base-port")
+		if err != nil {
+			logger.Error("invalid arguments", "err", err)
+			return
+		}
+		host, _ := args.String("--host")
+		for i := range targets {
+			listens = append(listens, net.JoinHostPort(host, strconv.Itoa(basePort+i)))
+		}
+	} else {
+		for _, route := range args["<route>"].([]string) {
+			parts := strings.SplitN(route, "=", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				logger.Error("invalid arguments", "err", fmt.Errorf("route %q is not <listen>=<target>", route))
+				return
+			}
+			listens = append(listens, parts[0])
+			targets = append(targets, parts[1])
+		}
+	}
+
+	for _, target := range targets {
+		if _, _, err := endpoint.Parse(target); err != nil {
+			logger.Error("invalid target", "err", err)
+			return
+		}
+	}
+
+	l := &links{listens: listens}
+	defer l.Close()
+	for i, target := range targets {
+		lis, err := endpoint.Listen(listens[i])
+		if err != nil {
+			logger.Error("listen", "addr", listens[i], "err", err)
+			return
+		}
+
+		// every link gets its own seed, so that adding a route does not
+		// change the choices of the others
+		p := chaos.New(target, seed+int64(i)<<32, logger.With("link", i))
+		l.proxies = append(l.proxies, p)
+		go p.Serve(lis)
+		logger.Info("link", "link", i, "listen", listens[i], "target", target)
+	}
+
+	path, _ := args.String("--resolver-file")
+	if err := writeResolverFile(path, listens); err != nil {
+		logger.Error("write resolver file", "path", path, "err", err)
+		return
+	}
+	logger.Info("started", "links", len(listens), "seed", seed, "resolver_file", path)
+
+	if addr, err := args.String("--control"); err == nil {
+		lis, err := endpoint.Listen(addr)
+		if err != nil {
+			logger.Error("listen", "addr", addr, "err", err)
+			return
+		}
+
+		s := grpc.NewServer()
+		api.RegisterChaosServer(s, l)
+		defer s.Stop()
+		go s.Serve(lis)
+		logger.Info("serving control service", "addr", addr)
+	}
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	if noPrompt, _ := args.Bool("--no-prompt"); noPrompt {
+		<-interrupted
+		return
+	}
+
+	done := make(chan bool)
+	go func() {
+		runPrompt(l)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-interrupted:
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"github.com/1xyz/grpc-playground/config"
+	"github.com/1xyz/grpc-playground/logging"
+	"github.com/1xyz/grpc-playground/node"
+	"github.com/docopt/docopt-go"
+)
+
+// envPrefix of the environment variables overriding settings, e.g.
+// ECHO_SERVER_TICK_INTERVAL=500ms
+const envPrefix = "ECHO_SERVER_"
+
+// serverConfig is the node's config w/ the log format on top, as read from
+// the config file.
+type serverConfig struct {
+	node.Config
+
+	LogFormat string `json:"log_format"`
+}
+
+func defaultServerConfig() serverConfig {
+	cfg := serverConfig{Config: node.DefaultConfig(), LogFormat: logging.FormatLogfmt}
+	cfg.LogLevel = "info"
+	return cfg
+}
+
+// loadConfig reads the config file, the environment & the flags in args on
+// top of the defaults.
+func loadConfig(args docopt.Opts) (serverConfig, error) {
+	cfg := defaultServerConfig()
+	path, _ := args.String("--config")
+	if err := config.Load(&cfg, path, envPrefix); err != nil {
+		return cfg, err
+	}
+	if err := config.ApplyFlags(&cfg, args); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+func (c serverConfig) Validate() error {
+	if err := c.Config.Validate(); err != nil {
+		return err
+	}
+	if c.LogFormat != logging.FormatLogfmt && c.LogFormat != logging.FormatJSON {
+		return fmt.Errorf("log_format = %q is not logfmt or json", c.LogFormat)
+	}
+	return nil
+}
@@ -1,193 +1,139 @@
 package main
 
 import (
-	"github.com/1xyz/grpc-playground/api"
+	"github.com/1xyz/grpc-playground/config"
+	"github.com/1xyz/grpc-playground/logging"
+	"github.com/1xyz/grpc-playground/node"
 	"github.com/docopt/docopt-go"
-	"github.com/google/uuid"
-	"golang.org/x/net/context"
-	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
-	"log"
-	"net"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
-type EchoServer struct {
-	api.UnimplementedEchoServer
-
-	id string
-
-	tickDuration time.Duration
-
-	shutdownCh chan bool
-
-	isLeader bool
-}
-
-func (es *EchoServer) Echo(ctx context.Context, req *api.EchoRequest) (*api.EchoResponse, error) {
-	log.Printf("echo req = %v\n", req)
-	return &api.EchoResponse{
-		ServerId: es.id,
-		ClientId: req.ClientId,
-		Clock:    now(),
-	}, nil
-}
-
-func (es *EchoServer) StreamEcho(req *api.EchoRequest, stream api.Echo_StreamEchoServer) error {
-	ticker := time.NewTicker(es.tickDuration)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case t := <-ticker.C:
-			sec := t.UTC().Unix()
-			stream.Send(&api.EchoResponse{
-				ServerId: es.id,
-				ClientId: req.ClientId,
-				Clock:    sec,
-			})
-
-		case <-es.shutdownCh:
-			return nil
-		}
-	}
-}
-
-func (es *EchoServer) FailingEcho(ctx context.Context, req *api.EchoRequest) (*api.EchoResponse, error) {
-	log.Printf("echo req = %v\n", req)
-	return nil, status.Error(codes.Unavailable, "I am just gonna fail")
-}
-
-func (es *EchoServer) IsLeader(ctx context.Context, e *api.Empty) (*api.IsLeaderResponse, error) {
-	return &api.IsLeaderResponse{IsLeader: es.isLeader}, nil
-}
-
-func now() int64 {
-	return time.Now().UTC().Unix()
-}
-
-// ////////////////////////////////////////////////////////////////////////////////////////
-
-type HealthCheckServer struct {
-	healthgrpc.UnimplementedHealthServer
-
-	echoServer *EchoServer
-
-	tickDuration time.Duration
-
-	shutdownCh chan bool
-}
-
-func (h *HealthCheckServer) Check(ctx context.Context,
-	req *healthgrpc.HealthCheckRequest) (*healthgrpc.HealthCheckResponse, error) {
-	log.Printf("check: req svc: %v", req.Service)
-
-	s := healthgrpc.HealthCheckResponse_UNKNOWN
-	if h.echoServer == nil {
-		s = healthgrpc.HealthCheckResponse_UNKNOWN
-	} else {
-		if h.echoServer.isLeader {
-			s = healthgrpc.HealthCheckResponse_SERVING
-		} else {
-			s = healthgrpc.HealthCheckResponse_NOT_SERVING
-		}
-	}
-
-	return &healthgrpc.HealthCheckResponse{
-		Status: s,
-	}, nil
-}
-
-func (h *HealthCheckServer) Watch(req *healthgrpc.HealthCheckRequest, stream healthgrpc.Health_WatchServer) error {
-	ticker := time.NewTicker(h.tickDuration)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case t := <-ticker.C:
-			resp, err := h.Check(stream.Context(), req)
-			if err != nil {
-				return err
-			}
-
-			log.Printf("sending resp at %v resp=%v", t, resp)
-			stream.Send(resp)
-
-		case <-h.shutdownCh:
-			return nil
-		}
-	}
-}
-
-// ////////////////////////////////////////////////////////////////////////////////////////
-
 func main() {
-	usage := `usage: server [--address=<address>] [--leader]
+	usage := `usage: server [--config=<path>] [--print-config] [--address=<address>] [--listen=<endpoints>]
+              [--leader] [--reflection] [--http=<address>] [--openapi=<path>] [--grpc-web=<address>]
+              [--cors-origins=<origins>] [--rate-limit=<spec>] [--max-concurrent=<n>] [--latency=<duration>]
+              [--clock-offset=<duration>] [--history-size=<n>] [--history-file=<path>]
+              [--peers=<addresses>] [--topic-retention=<n>] [--max-topics=<n>] [--stream-buffer=<n>]
+              [--tick-interval=<duration>] [--health-interval=<duration>] [--faults=<spec>]
+              [--health-override=<status>] [--log-level=<level>] [--log-format=<format>]
+
+Settings are the defaults, overridden by the --config file, then by
+ECHO_SERVER_<SETTING> environment variables, e.g. ECHO_SERVER_TICK_INTERVAL=500ms,
+then by flags. A setting is named like its flag w/ underscores, e.g.
+tick_interval, --print-config lists them all.
+
+On SIGHUP, or the ReloadConfig admin call, the settings are read again &
+tick_interval, faults, rate_limit, health_override & log_level are applied
+w/o dropping connections. A config that is invalid or changes any other
+setting is rejected as a whole & the server keeps running as it was.
+
+Every endpoint answers HTTP/1.1 next to gRPC, told apart by the first bytes
+of a connection: /healthz, /readyz (503 unless the health service reports
+SERVING), /metrics in the Prometheus text format, /status as JSON &
+/debug/pprof/.
+
+Calls failed by a rate limit, by FailingEcho or by faults w/ a pushback tell
+clients when to retry in RetryInfo & the grpc-retry-pushback-ms trailer,
+which grpc clients w/ a retry policy wait by. FailingEcho asks for the
+failing_echo_pushback setting, 1s by default, negative for no retry.
 
 options:
-   --address=<address>  Listen Address [default: :11000]..
+   --config=<path>      Read settings from this YAML or JSON file.
+   --print-config       Print the settings as YAML & exit, e.g. to start a config file.
+   --address=<address>  Listen Address, :11000 by default, can be any endpoint below.
+   --listen=<endpoints> Comma separated endpoints to serve on as well, each one of
+                          host:port or tcp://host:port  tcp, tcp4:// or tcp6:// for one IP version
+                          unix:///path or unix:path     unix socket
+                          unix-abstract:name            Linux abstract unix socket
    --leader             Is leader.
+   --reflection         Register the server reflection service.
+   --http=<address>     Serve the REST/JSON gateway on this address.
+   --openapi=<path>     OpenAPI document served by the gateway, api/api.swagger.json by default.
+   --grpc-web=<address>        Serve grpc-web on this address, can be the listen address.
+   --cors-origins=<origins>    Comma separated origins allowed to call grpc-web, * by default.
+   --rate-limit=<spec>         Per client token buckets as method=rate:burst,... where
+                               method is * for all methods but the health, admin, reflection
+                               & channelz ones, e.g. *=100:200,Echo=10:20.
+   --max-concurrent=<n>        Unary calls handled at once, others queue, 0 is unbounded.
+   --latency=<duration>        Delay added to every Echo.
+   --clock-offset=<duration>   Shift the physical clock of this server, e.g. -2s.
+   --history-size=<n>          Echo records kept for ListHistory, 10000 by default.
+   --history-file=<path>       Persist the echo history to this file across restarts.
+   --peers=<addresses>         Comma separated addresses of the other servers of the cluster.
+   --topic-retention=<n>       Messages per topic retained for replay, 1000 by default.
+   --max-topics=<n>            Topics w/ subscribers or retained messages at once, 1000 by default.
+   --stream-buffer=<n>         StreamEcho ticks buffered for resumed streams, 60 by default.
+   --tick-interval=<duration>  Period of StreamEcho ticks, 1s by default.
+   --health-interval=<duration>
+                               Period of health updates sent to watchers, 1s by default.
+   --faults=<spec>             Fail calls on purpose as method=code:probability:pushback,...
+                               where method is * for all methods & pushback, a duration or
+                               never, tells clients when to retry, e.g. Echo=UNAVAILABLE:0.1:2s.
+                               The health, admin, reflection & channelz methods never fail.
+   --health-override=<status>  Report serving or not_serving whatever the leadership.
+   --log-level=<level>         Log debug, info, warn or error & above, can be changed w/
+                               the SetLogLevel admin call, info by default.
+   --log-format=<format>       Log records as logfmt or json, logfmt by default.
 `
 	parser := &docopt.Parser{OptionsFirst: true}
 	args, err := parser.ParseArgs(usage, nil, "1.0")
 	if err != nil {
-		log.Printf("error = %v", err)
+		logging.Default().Error("invalid arguments", "err", err)
 		return
 	}
 
-	log.Printf("%v\n", args)
-	addr, err := args.String("--address")
+	cfg, err := loadConfig(args)
 	if err != nil {
-		log.Printf("err = %v\n", err)
-		return
+		logging.Default().Error("invalid config", "err", err)
+		os.Exit(2)
 	}
-
-	isLeader, err := args.Bool("--leader")
-	if err != nil {
-		log.Printf("err = %v\n", err)
+	if printConfig, _ := args.Bool("--print-config"); printConfig {
+		if err := config.Write(os.Stdout, &cfg); err != nil {
+			logging.Default().Error("print config", "err", err)
+			os.Exit(1)
+		}
 		return
 	}
 
-	s := grpc.NewServer()
-	echoServer := newEchoServer(isLeader)
-	api.RegisterEchoServer(s, echoServer)
-	healthcheck := newHealthCheckServer(echoServer)
-	healthgrpc.RegisterHealthServer(s, healthcheck)
-
-	log.Printf("addr = %v\n", addr)
-	lis, err := net.Listen("tcp", addr)
+	logger, err := logging.Setup(cfg.LogLevel, cfg.LogFormat)
 	if err != nil {
-		log.Panic(err)
+		logging.Default().Error("invalid arguments", "err", err)
+		return
 	}
 
-	log.Printf("started...")
-	if err := s.Serve(lis); err != nil {
-		log.Panic(err)
+	path, _ := args.String("--config")
+	logger.Debug("parsed arguments", "args", args, "config", path)
+	cfg.Logger = logger
+	cfg.Loader = func() (node.Config, error) {
+		next, err := loadConfig(args)
+		if err != nil {
+			return node.Config{}, err
+		}
+		if next.LogFormat != cfg.LogFormat {
+			return node.Config{}, status.Errorf(codes.FailedPrecondition,
+				"log_format cannot change w/o a restart, from %v to %v", cfg.LogFormat, next.LogFormat)
+		}
+		return next.Config, nil
 	}
-}
-
-func newEchoServer(isLeader bool) *EchoServer {
-	a := &EchoServer{
-		id:           uuid.New().String(),
-		tickDuration: time.Second,
-		shutdownCh:   make(chan bool),
-		isLeader:     isLeader,
+	n, err := node.New(cfg.Config)
+	if err != nil {
+		logger.Error("create node", "err", err)
+		return
 	}
 
-	log.Printf("new server id = %v isLeader = %v\n",
-		a.isLeader, a.isLeader)
-	return a
-}
-
-func newHealthCheckServer(server *EchoServer) *HealthCheckServer {
-	h := &HealthCheckServer{
-		echoServer:   server,
-		tickDuration: time.Second,
-		shutdownCh:   make(chan bool),
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			n.ReloadConfig()
+		}
+	}()
+	if err := n.ListenAndServe(); err != nil {
+		logger.Error("serve", "err", err)
+		os.Exit(1)
 	}
-
-	log.Printf("created health checker server\n")
-	return h
 }